@@ -44,6 +44,34 @@ func RunCli() {
 				Destination: &verbose,
 			},
 		},
+		Commands: []*cli.Command{
+			{
+				Name:  "reevaluate",
+				Usage: "Run the current filters against the active backgrounds, marking the ones that fail stale",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "only list the backgrounds that would be rotated out",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return PrintReevaluation(&BgFreshConfig{
+						Config: config,
+						Log:    logInit(verbose),
+					}, c.Bool("dry-run"), os.Stdout)
+				},
+			},
+			{
+				Name:  "stats",
+				Usage: "Print how many candidates each filter accepted and rejected per source",
+				Action: func(c *cli.Context) error {
+					return PrintStats(&BgFreshConfig{
+						Config: config,
+						Log:    logInit(verbose),
+					}, os.Stdout)
+				},
+			},
+		},
 		Action: func(c *cli.Context) error {
 			config := &BgFreshConfig{
 				Config: config,
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bgfreshd/internal/config"
+	"bgfreshd/pkg/background"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// metadata keys describing a background's look, saved so later candidates can be compared against it
+const (
+	meanLabKey        = "mean-lab"
+	perceptualHashKey = "perceptual-hash"
+)
+
+// maxDiversityAttempts is how many candidates are loaded looking for one that fits the rules
+// before falling back to the oldest deferred candidate
+const maxDiversityAttempts = 10
+
+// activeSummary holds what the diversity rules compare of an active background
+type activeSummary struct {
+	name    string
+	source  string
+	author  string
+	meanLab *background.Lab
+	hash    *uint64
+}
+
+func summarize(name string, meta map[string]string) activeSummary {
+	summary := activeSummary{
+		name:   name,
+		source: meta["source-name"],
+		author: normalizeAuthor(meta["author"]),
+	}
+
+	if lab, err := parseLab(meta[meanLabKey]); err == nil {
+		summary.meanLab = &lab
+	}
+	if hash, err := strconv.ParseUint(meta[perceptualHashKey], 16, 64); err == nil {
+		summary.hash = &hash
+	}
+
+	return summary
+}
+
+// describeLook records the average color and perceptual hash of a loaded candidate in its metadata
+func describeLook(bg background.Background) {
+	if len(bg.GetMetadata(perceptualHashKey)) != 0 {
+		return
+	}
+
+	analysis := bg.GetAnalysis()
+	lab := analysis.Whole().MeanLab()
+	bg.AddMetadata(meanLabKey, fmt.Sprintf("%.2f,%.2f,%.2f", lab.L, lab.A, lab.B))
+	bg.AddMetadata(perceptualHashKey, fmt.Sprintf("%016x", analysis.PerceptualHash()))
+}
+
+// checkDiversity returns why a candidate doesn't fit alongside the active backgrounds, or an empty string if it does
+func checkDiversity(rules *config.DiversityConfig, maxBackgrounds int, bg background.Background, active []activeSummary) string {
+	describeLook(bg)
+	candidate := summarize(bg.GetName(), metadataOf(bg))
+
+	if rules.MaxSourceShare != 0 && len(candidate.source) != 0 {
+		// every source may always fill at least one slot
+		allowed := int(math.Max(1, math.Floor(rules.MaxSourceShare*float64(maxBackgrounds))))
+		count := 0
+		for _, a := range active {
+			if a.source == candidate.source {
+				count++
+			}
+		}
+
+		if count >= allowed {
+			return fmt.Sprintf("source %s already fills %d of %d allowed slots", candidate.source, count, allowed)
+		}
+	}
+
+	if rules.MaxPerAuthor != 0 && len(candidate.author) != 0 {
+		count := 0
+		for _, a := range active {
+			if a.author == candidate.author {
+				count++
+			}
+		}
+
+		if count >= rules.MaxPerAuthor {
+			return fmt.Sprintf("author %s already has %d active backgrounds", candidate.author, count)
+		}
+	}
+
+	for _, a := range active {
+		if rules.MinColorDistance != 0 && a.meanLab != nil {
+			if distance := candidate.meanLab.Distance(*a.meanLab); distance < rules.MinColorDistance {
+				return fmt.Sprintf("color distance %.2f to %s < minColorDistance %.2f", distance, a.name, rules.MinColorDistance)
+			}
+		}
+
+		if rules.MinPerceptualDistance != 0 && a.hash != nil {
+			if distance := background.HashDistance(*candidate.hash, *a.hash); distance < rules.MinPerceptualDistance {
+				return fmt.Sprintf("perceptual distance %d to %s < minPerceptualDistance %d", distance, a.name, rules.MinPerceptualDistance)
+			}
+		}
+	}
+
+	return ""
+}
+
+func metadataOf(bg background.Background) map[string]string {
+	meta := map[string]string{}
+	for _, key := range bg.GetMetadataKeys() {
+		meta[key] = bg.GetMetadata(key)
+	}
+
+	return meta
+}
+
+// normalizeAuthor ignores case and the placeholder reddit uses for deleted accounts
+func normalizeAuthor(author string) string {
+	author = strings.ToLower(strings.TrimSpace(author))
+	if author == "[deleted]" {
+		return ""
+	}
+
+	return author
+}
+
+func parseLab(val string) (background.Lab, error) {
+	parts := strings.Split(val, ",")
+	if len(parts) != 3 {
+		return background.Lab{}, fmt.Errorf("invalid lab color \"%s\"", val)
+	}
+
+	var channels [3]float64
+	for i, part := range parts {
+		parsed, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return background.Lab{}, err
+		}
+		channels[i] = parsed
+	}
+
+	return background.Lab{L: channels[0], A: channels[1], B: channels[2]}, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bgfreshd/internal/config"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// hook events
+const (
+	eventAdded            = "added"
+	eventRemoved          = "removed"
+	eventRotationComplete = "rotationComplete"
+	eventError            = "error"
+)
+
+// runAddedHooks runs the added hooks for a background whose files were just written
+func (b *bgFreshService) runAddedHooks(name string) {
+	if b.config.Hooks == nil || len(b.config.Hooks.OnAdded) == 0 {
+		return
+	}
+
+	b.runHooks(eventAdded, b.config.Hooks.OnAdded, b.backgroundEnv(name))
+}
+
+// removedHookEnv describes a background for the removed hooks, read before its files and metadata go
+func (b *bgFreshService) removedHookEnv(name string) []string {
+	if b.config.Hooks == nil || len(b.config.Hooks.OnRemoved) == 0 {
+		return nil
+	}
+
+	return b.backgroundEnv(name)
+}
+
+func (b *bgFreshService) runRemovedHooks(env []string) {
+	if b.config.Hooks == nil || len(b.config.Hooks.OnRemoved) == 0 {
+		return
+	}
+
+	b.runHooks(eventRemoved, b.config.Hooks.OnRemoved, env)
+}
+
+// runRotationCompleteHooks runs the rotation complete hooks with the newest active background,
+// along with every active background's file
+func (b *bgFreshService) runRotationCompleteHooks() {
+	if b.config.Hooks == nil || len(b.config.Hooks.OnRotationComplete) == 0 {
+		return
+	}
+
+	entries, err := b.playlistEntries("")
+	if err != nil {
+		b.logger.Warnf("error listing backgrounds for the rotation complete hooks: %s", err.Error())
+		return
+	}
+
+	var env []string
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, entry.filename)
+	}
+	if len(entries) != 0 {
+		env = b.backgroundEnv(entries[len(entries)-1].name)
+	}
+	env = append(env, fmt.Sprintf("BGFRESHD_ACTIVE=%s", strings.Join(files, "\n")))
+
+	b.runHooks(eventRotationComplete, b.config.Hooks.OnRotationComplete, env)
+}
+
+// runErrorHooks runs the error hooks and returns the error, so failures can be reported where they're returned
+func (b *bgFreshService) runErrorHooks(err error) error {
+	if b.config.Hooks == nil || len(b.config.Hooks.OnError) == 0 {
+		return err
+	}
+
+	b.runHooks(eventError, b.config.Hooks.OnError, []string{fmt.Sprintf("BGFRESHD_ERROR=%s", err.Error())})
+	return err
+}
+
+// backgroundEnv describes a background to hooks, its name, main file, every file written for it and its
+// metadata as BGFRESHD_META_<KEY>, IE BGFRESHD_META_TITLE
+func (b *bgFreshService) backgroundEnv(name string) []string {
+	meta, err := b.db.GetMetadata(name)
+	if err != nil {
+		b.logger.Warnf("error reading metadata of %s for hooks: %s", name, err.Error())
+	}
+
+	env := []string{fmt.Sprintf("BGFRESHD_NAME=%s", name)}
+	if filename := playlistFile(b.config.OutputPath, name, meta, ""); len(filename) != 0 {
+		if absolute, err := filepath.Abs(filename); err == nil {
+			filename = absolute
+		}
+		env = append(env, fmt.Sprintf("BGFRESHD_FILE=%s", filename))
+	}
+
+	var files []string
+	for _, filename := range backgroundFiles(b.config.OutputPath, name, meta) {
+		if absolute, err := filepath.Abs(filename); err == nil {
+			filename = absolute
+		}
+		files = append(files, filename)
+	}
+	env = append(env, fmt.Sprintf("BGFRESHD_FILES=%s", strings.Join(files, "\n")))
+
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, fmt.Sprintf("BGFRESHD_META_%s=%s", envKey(key), meta[key]))
+	}
+
+	return env
+}
+
+// runHooks runs each hook in turn, logging its output. hooks failing is logged rather than stopping the rotation
+func (b *bgFreshService) runHooks(event string, hooks []config.HookConfig, env []string) {
+	env = append(append(os.Environ(), fmt.Sprintf("BGFRESHD_EVENT=%s", event)), env...)
+	for _, hook := range hooks {
+		output, err := runHook(b.ctx, hook, env)
+
+		logger := b.logger.WithField("hook", event)
+		scanner := bufio.NewScanner(bytes.NewReader(output))
+		for scanner.Scan() {
+			logger.Info(scanner.Text())
+		}
+
+		if err != nil {
+			logger.Warnf("hook \"%s\" failed: %s", hook.Command, err.Error())
+		}
+	}
+}
+
+// runHook runs the command with sh -c, returning its combined output. the output goes to a temp file rather than
+// a pipe so commands that leave children holding it open, IE swww's daemon, don't keep the hook from returning
+func runHook(ctx context.Context, hook config.HookConfig, env []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(hook.Timeout)*time.Second)
+	defer cancel()
+
+	out, err := ioutil.TempFile("", "bgfreshd-hook-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	cmd.Env = env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %ds", hook.Timeout)
+	}
+
+	if _, seekErr := out.Seek(0, 0); seekErr != nil {
+		return nil, err
+	}
+	output, readErr := ioutil.ReadAll(out)
+	if readErr != nil {
+		return nil, err
+	}
+
+	return output, err
+}
+
+// envKey makes a metadata key usable as part of an environment variable name, IE created-date as CREATED_DATE
+func envKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, key)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bgfreshd/pkg/sink"
+	"io/ioutil"
+	"os"
+)
+
+// syncSinks brings the sinks that may have drifted from the active backgrounds back in line, uploading the files
+// they're missing and removing those bgfreshd wrote that are no longer active. sinks that still fail are retried
+// the next time round
+func syncSinks(b *bgFreshService) {
+	b.sinkLock.Lock()
+	defer b.sinkLock.Unlock()
+
+	var mirrored map[string]string
+	for _, s := range b.sinks {
+		if !b.unsynced[s] {
+			continue
+		}
+
+		// listed once the first sink needs it, the lock keeps it current for the rest
+		if mirrored == nil {
+			var err error
+			if mirrored, err = b.mirroredFiles(); err != nil {
+				b.logger.Warnf("error listing files to sync to sinks: %s", err.Error())
+				return
+			}
+		}
+
+		if err := b.syncSink(s, mirrored); err != nil {
+			b.logger.Warnf("error syncing sink %s, retrying later: %s", s.GetName(), err.Error())
+			continue
+		}
+
+		b.logger.Infof("sink %s is in sync", s.GetName())
+		delete(b.unsynced, s)
+	}
+}
+
+// mirroredFiles maps the sink name of every file written for an active background, sidecars included, to its file
+func (b *bgFreshService) mirroredFiles() (map[string]string, error) {
+	active, err := b.db.GetActiveBackgrounds()
+	if err != nil {
+		return nil, err
+	}
+
+	mirrored := map[string]string{}
+	for _, name := range active {
+		for _, filename := range b.getFiles(name) {
+			for _, f := range []string{filename, sidecarFilename(filename)} {
+				if exists(f) {
+					mirrored[b.sinkName(f)] = f
+				}
+			}
+		}
+	}
+
+	return mirrored, nil
+}
+
+// syncSink removes the files bgfreshd wrote to the sink that aren't mirrored, then uploads what it's missing.
+// anything else the sink holds is left alone, as are files already there, every sink only exposes complete files
+func (b *bgFreshService) syncSink(s sink.OutputSink, mirrored map[string]string) error {
+	written, err := b.db.GetSinkFiles(s.GetName())
+	if err != nil {
+		return err
+	}
+
+	for name := range written {
+		if _, ok := mirrored[name]; ok {
+			continue
+		}
+
+		if err := b.removeSinkFile(s, name); err != nil {
+			return err
+		}
+	}
+
+	held, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	present := map[string]bool{}
+	for _, name := range held {
+		present[name] = true
+	}
+
+	for name, filename := range mirrored {
+		if present[name] {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := b.putSinkFile(s, name, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// putSinkFile writes a file to a sink, recording it first so it can be removed again even if the write
+// reaches the sink but reports failing
+func (b *bgFreshService) putSinkFile(s sink.OutputSink, name string, data []byte) error {
+	if err := b.db.RecordSinkFile(s.GetName(), name); err != nil {
+		return err
+	}
+
+	return s.Put(name, data)
+}
+
+// removeSinkFile removes a file from a sink, forgetting it once it's gone
+func (b *bgFreshService) removeSinkFile(s sink.OutputSink, name string) error {
+	if err := s.Remove(name); err != nil {
+		return err
+	}
+
+	return b.db.ForgetSinkFile(s.GetName(), name)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bgfreshd/internal/config"
+	"bgfreshd/internal/output"
+	"bgfreshd/pkg/background"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"image"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filesKey is the metadata key listing the files written for a background
+const filesKey = "files"
+
+// sidecarExtension replaces the extension of an image for its json sidecar
+const sidecarExtension = ".json"
+
+// metadata keys holding the size of the image a background was accepted with, before it was cropped and scaled
+const (
+	originalWidthKey  = "original-width"
+	originalHeightKey = "original-height"
+)
+
+// renderedImage is a single file written for a background
+type renderedImage struct {
+	// suffix is appended to the background's filename, naming the monitor and variant
+	suffix  string
+	img     image.Image
+	changed bool
+
+	// variant is the name of the variant the image was derived for, empty for the primary image
+	variant string
+
+	encoded   []byte
+	extension string
+
+	// relative is the file's slash separated path within the output path, filename its full path
+	relative string
+	filename string
+}
+
+// recordOriginal notes the size of the source image, so the background can be judged by filters again
+// once only its cropped and scaled files are left
+func recordOriginal(bg background.Background) error {
+	size, err := bg.GetDimensions()
+	if err != nil {
+		return err
+	}
+
+	bg.AddMetadata(originalWidthKey, strconv.Itoa(size.X))
+	bg.AddMetadata(originalHeightKey, strconv.Itoa(size.Y))
+	return nil
+}
+
+// render prepares the images written for a background with the output's transforms and caption applied,
+// followed by a copy of each for every variant. caption is nil when there's no caption configured
+func render(cfg *config.Config, caption *output.Caption, bg background.Background) ([]renderedImage, error) {
+	fitted := fit(cfg, bg)
+	if cfg.Output == nil {
+		return fitted, nil
+	}
+
+	transforms, err := output.NewTransforms(cfg.Output.Transforms)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := captionText(caption, bg)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := make([]renderedImage, 0, len(fitted)*(1+len(cfg.Output.Variants)))
+	for _, r := range fitted {
+		primary := r
+		primary.img = output.ApplyTransforms(r.img, transforms)
+		primary.changed = r.changed || len(transforms) != 0
+
+		if len(text) != 0 {
+			if primary.img, err = caption.Draw(primary.img, text); err != nil {
+				return nil, err
+			}
+			primary.changed = true
+		}
+
+		rendered = append(rendered, primary)
+	}
+
+	// variants are derived from the fitted images so they don't stack on the output's transforms
+	for _, variant := range cfg.Output.Variants {
+		transforms, err := output.NewTransforms(variant.Transforms)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range fitted {
+			rendered = append(rendered, renderedImage{
+				suffix:  fmt.Sprintf("%s-%s", r.suffix, variant.Name),
+				img:     output.ApplyTransforms(r.img, transforms),
+				changed: r.changed || len(transforms) != 0,
+				variant: variant.Name,
+			})
+		}
+	}
+
+	return rendered, nil
+}
+
+// captionText builds the caption's text from the background's metadata, empty when there's no caption
+func captionText(caption *output.Caption, bg background.Background) (string, error) {
+	if caption == nil {
+		return "", nil
+	}
+
+	meta := metadataOf(bg)
+	meta["name"] = bg.GetName()
+	text, err := caption.Text(meta)
+	if err != nil {
+		return "", err
+	}
+
+	bg.AddMetadata("caption", text)
+	return text, nil
+}
+
+// fit crops and scales the background to the output, one image per monitor in the perMonitor layout,
+// otherwise a single one. the crop, if any, is recorded in the background's metadata
+func fit(cfg *config.Config, bg background.Background) []renderedImage {
+	out := cfg.Output
+	width, height := 0, 0
+	if out != nil {
+		width, height = out.Size()
+	}
+
+	upright := uprightBackground(bg)
+	if width == 0 {
+		return []renderedImage{{img: upright.GetImage()}}
+	}
+
+	if out.Layout == config.LayoutPerMonitor && len(out.Monitors) != 0 {
+		monitors := make([]output.Monitor, 0, len(out.Monitors))
+		for _, m := range out.Monitors {
+			monitors = append(monitors, output.Monitor{
+				Name:   m.Name,
+				Bounds: image.Rect(m.X, m.Y, m.X+m.Width, m.Y+m.Height),
+			})
+		}
+
+		images, crop := output.FitMonitors(upright, monitors, out.Crop)
+		bg.AddMetadata("crop", output.FormatRect(crop))
+
+		rendered := make([]renderedImage, 0, len(images))
+		for i, img := range images {
+			rendered = append(rendered, renderedImage{
+				suffix:  "-" + monitors[i].Name,
+				img:     img,
+				changed: true,
+			})
+		}
+		return rendered
+	}
+
+	img, crop := output.Fit(upright, width, height, out.Crop)
+	bg.AddMetadata("crop", output.FormatRect(crop))
+	return []renderedImage{{img: img, changed: true}}
+}
+
+// uprightBackground is the background turned the way its EXIF orientation says it's displayed, only its image and
+// analysis are meant to be used. the original is still written untouched when nothing changes, tag included
+func uprightBackground(bg background.Background) background.Background {
+	orientation, err := strconv.Atoi(bg.GetMetadata(background.ExifOrientation))
+	if err != nil || orientation < 2 || orientation > 8 {
+		return bg
+	}
+
+	return background.FromImage(output.Orient(bg.GetImage(), orientation), bg.GetName())
+}
+
+// encode encodes the rendered images in the configured format. images whose metadata can't be embedded,
+// IE when the camera's EXIF is malformed, are written without it
+func encode(cfg *config.Config, bg background.Background, rendered []renderedImage, logger *logrus.Entry) error {
+	format, quality := output.FormatOriginal, 100
+	if cfg.Output != nil {
+		format, quality = cfg.Output.Format, cfg.Output.Quality
+	}
+
+	for i := range rendered {
+		r := &rendered[i]
+		encoded, encodedFormat, err := output.Encode(bg, r.img, r.changed, format, quality)
+		if err != nil {
+			return err
+		}
+
+		if cfg.Output != nil && cfg.Output.EmbedMetadata && encodedFormat == output.FormatJpeg {
+			if embedded, err := output.EmbedJpegAttribution(encoded, attribution(bg)); err != nil {
+				logger.Warnf("unable to embed metadata in %s: %s", bg.GetName(), err.Error())
+			} else {
+				encoded = embedded
+			}
+		}
+
+		r.encoded = encoded
+		r.extension = output.Extension(encodedFormat)
+	}
+
+	return nil
+}
+
+// attribution credits the background's author and where it was found
+func attribution(bg background.Background) output.Attribution {
+	source := bg.GetMetadata("permalink")
+	for _, key := range []string{"url", "path", "source-name"} {
+		if len(source) == 0 {
+			source = bg.GetMetadata(key)
+		}
+	}
+
+	return output.Attribution{
+		Title:   bg.GetMetadata("title"),
+		Creator: bg.GetMetadata("author"),
+		Source:  source,
+	}
+}
+
+// sidecar encodes the background's metadata as json for the sidecar of a written image
+func sidecar(bg background.Background, filename string) ([]byte, error) {
+	meta := metadataOf(bg)
+	meta["name"] = bg.GetName()
+	meta["file"] = filepath.Base(filename)
+	meta["created_date"] = bg.GetCreatedDate().Format(time.RFC3339)
+	meta["expires_on"] = bg.GetExpiry().Format(time.RFC3339)
+
+	// titles are kept readable rather than escaped for embedding in html
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(meta); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sidecarFilename is where the json sidecar of an image goes, its filename with a json extension
+func sidecarFilename(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + sidecarExtension
+}
+
+// recordFiles lists the rendered files in the background's metadata by their path within the output path, so
+// changes to the naming still find them. every file is listed under files so rotation removes them, and each
+// variant's are also listed under its own key
+func recordFiles(bg background.Background, rendered []renderedImage) {
+	names := make([]string, 0, len(rendered))
+	variants := map[string][]string{}
+	var variantOrder []string
+	for _, r := range rendered {
+		names = append(names, r.relative)
+		if len(r.variant) == 0 {
+			continue
+		}
+
+		if _, ok := variants[r.variant]; !ok {
+			variantOrder = append(variantOrder, r.variant)
+		}
+		variants[r.variant] = append(variants[r.variant], r.relative)
+	}
+
+	bg.AddMetadata(filesKey, strings.Join(names, ","))
+	for _, variant := range variantOrder {
+		bg.AddMetadata(variantFilesKey(variant), strings.Join(variants[variant], ","))
+	}
+}
+
+// variantFilesKey is the metadata key listing the files written for a variant
+func variantFilesKey(variant string) string {
+	return fmt.Sprintf("%s-%s", filesKey, variant)
+}
+
+// maxNameAttempts bounds how many numbered names are tried when a filename is taken
+const maxNameAttempts = 1000
+
+// nameFiles places the rendered images according to the output's filename and folder templates. when any of
+// the files, or their sidecars, would replace an existing file the name is numbered, IE name-2
+func nameFiles(cfg *config.Config, bg background.Background, rendered []renderedImage) error {
+	filenameTemplate, folderTemplate, sidecars := "", "", false
+	if cfg.Output != nil {
+		filenameTemplate, folderTemplate, sidecars = cfg.Output.Filename, cfg.Output.Folder, cfg.Output.Sidecar
+	}
+
+	namer, err := output.NewNamer(filenameTemplate, folderTemplate)
+	if err != nil {
+		return err
+	}
+
+	folder, base, err := namer.Name(output.NameFields(bg))
+	if err != nil {
+		return err
+	}
+
+	// the longest suffix, extension and number leave what's left of the filename limit to the name
+	reserved := len(fmt.Sprintf("-%d", maxNameAttempts))
+	longest := 0
+	for _, r := range rendered {
+		extension := r.extension
+		if sidecars && len(sidecarExtension) > len(extension) {
+			extension = sidecarExtension
+		}
+		if length := len(r.suffix) + len(extension); length > longest {
+			longest = length
+		}
+	}
+	base = output.TruncateName(base, output.MaxFilenameLength-reserved-longest)
+	if len(base) == 0 {
+		return fmt.Errorf("no room left in the filename of %s for its name", bg.GetName())
+	}
+
+	for attempt := 1; attempt <= maxNameAttempts; attempt++ {
+		name := base
+		if attempt > 1 {
+			name = fmt.Sprintf("%s-%d", base, attempt)
+		}
+
+		taken := false
+		for i := range rendered {
+			r := &rendered[i]
+			r.relative = path.Join(folder, name+r.suffix+r.extension)
+			r.filename = filepath.Join(cfg.OutputPath, filepath.FromSlash(r.relative))
+
+			if exists(r.filename) || (sidecars && exists(sidecarFilename(r.filename))) {
+				taken = true
+			}
+		}
+
+		if !taken {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no free filename for %s after %d attempts", base, maxNameAttempts)
+}
+
+func exists(filename string) bool {
+	_, err := os.Stat(filename)
+	return !os.IsNotExist(err)
+}
+
+// backgroundFiles returns the files written for a background, falling back to the
+// single file backgrounds saved before files were recorded used
+func backgroundFiles(outputPath string, name string, meta map[string]string) []string {
+	if len(meta[filesKey]) == 0 {
+		return []string{backgroundFilename(outputPath, name, ".jpeg")}
+	}
+
+	var files []string
+	for _, file := range strings.Split(meta[filesKey], ",") {
+		files = append(files, filepath.Join(outputPath, filepath.FromSlash(file)))
+	}
+
+	return files
+}
+
+func backgroundFilename(outputPath string, bgName string, extension string) string {
+	return filepath.Join(outputPath, bgName+extension)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/config"
+	"bgfreshd/internal/output"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// playlistEntry is the file an active background contributes to the playlists
+type playlistEntry struct {
+	name     string
+	filename string
+	created  time.Time
+}
+
+// updatePlaylists regenerates the playlist files from the active backgrounds, oldest first. failing to is
+// logged rather than stopping the rotation
+func (b *bgFreshService) updatePlaylists() {
+	cfg := b.config.Playlist
+	if cfg == nil {
+		return
+	}
+
+	entries, err := b.playlistEntries(cfg.Variant)
+	if err != nil {
+		b.logger.Warnf("error listing backgrounds for the playlists: %s", err.Error())
+		return
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, entry.filename)
+	}
+
+	// a slideshow can't be empty, without backgrounds it's removed along with the current link
+	if len(cfg.Gnome) != 0 && len(files) == 0 {
+		if err := removePlaylist(b.playlistPath(cfg.Gnome)); err != nil {
+			b.logger.Warnf("error removing gnome slideshow: %s", err.Error())
+		}
+	} else if len(cfg.Gnome) != 0 {
+		slideshow := output.GnomeSlideshow(files, cfg.Duration, cfg.Transition, time.Now())
+		if err := b.writePlaylist(cfg.Gnome, slideshow); err != nil {
+			b.logger.Warnf("error writing gnome slideshow: %s", err.Error())
+		}
+	}
+
+	if len(cfg.List) != 0 {
+		list := strings.Join(files, "\n")
+		if len(list) != 0 {
+			list += "\n"
+		}
+		if err := b.writePlaylist(cfg.List, []byte(list)); err != nil {
+			b.logger.Warnf("error writing background list: %s", err.Error())
+		}
+	}
+
+	if len(cfg.Current) != 0 && len(entries) == 0 {
+		if err := removePlaylist(b.playlistPath(cfg.Current)); err != nil {
+			b.logger.Warnf("error removing current background link: %s", err.Error())
+		}
+	} else if len(cfg.Current) != 0 {
+		if err := symlinkAtomic(pickCurrent(cfg.CurrentPick, entries).filename, b.playlistPath(cfg.Current)); err != nil {
+			b.logger.Warnf("error linking current background: %s", err.Error())
+		}
+	}
+}
+
+// removePlaylist removes a playlist file or link, already missing ones aren't an error
+func removePlaylist(filename string) error {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// playlistEntries returns the written file of each active background, or of its variant when one is given,
+// oldest first, skipping any that are missing
+func (b *bgFreshService) playlistEntries(variant string) ([]playlistEntry, error) {
+	active, err := b.db.GetActiveBackgrounds()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []playlistEntry
+	for _, name := range active {
+		meta, err := b.db.GetMetadata(name)
+		if err != nil {
+			return nil, err
+		}
+
+		filename := playlistFile(b.config.OutputPath, name, meta, variant)
+		if len(filename) == 0 || !exists(filename) {
+			continue
+		}
+
+		absolute, err := filepath.Abs(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		created, err := b.db.GetCreatedDate(name)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, playlistEntry{name: name, filename: absolute, created: created})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].created.Before(entries[j].created)
+	})
+
+	return entries, nil
+}
+
+// playlistFile is the first file written for a background's variant, or the first that isn't a variant's
+func playlistFile(outputPath string, name string, meta map[string]string, variant string) string {
+	if len(variant) != 0 {
+		files := meta[variantFilesKey(variant)]
+		if len(files) == 0 {
+			return ""
+		}
+		return filepath.Join(outputPath, filepath.FromSlash(strings.Split(files, ",")[0]))
+	}
+
+	variantFiles := map[string]bool{}
+	for key, val := range meta {
+		if !strings.HasPrefix(key, filesKey+"-") {
+			continue
+		}
+		for _, file := range strings.Split(val, ",") {
+			variantFiles[filepath.Join(outputPath, filepath.FromSlash(file))] = true
+		}
+	}
+
+	for _, filename := range backgroundFiles(outputPath, name, meta) {
+		if !variantFiles[filename] {
+			return filename
+		}
+	}
+
+	return ""
+}
+
+func pickCurrent(pick string, entries []playlistEntry) playlistEntry {
+	switch pick {
+	case config.PickOldest:
+		return entries[0]
+	case config.PickRandom:
+		return entries[rand.Intn(len(entries))]
+	}
+
+	return entries[len(entries)-1]
+}
+
+// playlistPath resolves relative playlist paths within the output path
+func (b *bgFreshService) playlistPath(filename string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+
+	return filepath.Join(b.config.OutputPath, filename)
+}
+
+func (b *bgFreshService) writePlaylist(filename string, data []byte) error {
+	filename = b.playlistPath(filename)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	return internal.WriteFileAtomic(filename, data, 0644)
+}
+
+// symlinkAtomic points link at target, replacing whatever link was there without a moment where it's missing
+func symlinkAtomic(target string, link string) error {
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(link), internal.TempFilePrefix+filepath.Base(link))
+	_ = os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, link); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bgfreshd/internal/config"
+	"bgfreshd/internal/db"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"text/tabwriter"
+)
+
+// failedBackground is an active background the current filters reject
+type failedBackground struct {
+	Name   string
+	Source string
+	Filter string
+	Reason string
+}
+
+// reevaluateActive runs the current filters against every active background accepted by different filters,
+// marking the ones that now fail stale unless dryRun is set
+func reevaluateActive(cfg *config.Config, d db.BackgroundDb, pl pipeline.Pipeline, logger *logrus.Entry, dryRun bool) ([]failedBackground, error) {
+	active, err := d.GetActiveBackgrounds()
+	if err != nil {
+		return nil, err
+	}
+
+	stale, err := d.GetStaleBackgrounds()
+	if err != nil {
+		return nil, err
+	}
+
+	// stale backgrounds are already on their way out
+	alreadyStale := map[string]bool{}
+	for _, name := range stale {
+		alreadyStale[name] = true
+	}
+
+	var failed []failedBackground
+	for _, name := range active {
+		if alreadyStale[name] {
+			continue
+		}
+
+		meta, err := d.GetMetadata(name)
+		if err != nil {
+			return nil, err
+		}
+
+		bg, ok := reevaluationBackground(cfg, d, name, meta)
+		if !ok {
+			logger.Debugf("leaving %s, it was accepted before its original image was recorded", name)
+			continue
+		}
+
+		result := pl.Reevaluate(bg)
+		switch {
+		case result.Unchanged:
+			continue
+		case result.Rejection == nil:
+			logger.Debugf("%s still passes the current filters", name)
+			if !dryRun {
+				if err := d.SetMetadata(name, pipeline.FilterFingerprintKey, result.Fingerprint); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case result.Rejection.Transient:
+			logger.Warnf("unable to reevaluate %s: %s", name, result.Rejection)
+			continue
+		}
+
+		logger.Infof("%s no longer passes %s: %s", name, result.Filter, result.Rejection)
+		failed = append(failed, failedBackground{
+			Name:   name,
+			Source: meta["source-name"],
+			Filter: result.Filter,
+			Reason: result.Rejection.Reason,
+		})
+
+		if !dryRun {
+			if err := d.MarkStale(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return failed, nil
+}
+
+// reevaluationBackground recreates an accepted background for the filters. the written files are cropped, scaled
+// and transformed for the output, so it's judged by the downscaled copy of the image it was accepted with and that
+// image's size. backgrounds saved before those were recorded can only be judged when the output is written untouched
+func reevaluationBackground(cfg *config.Config, d db.BackgroundDb, name string, meta map[string]string) (background.Background, bool) {
+	var bg background.Background
+	width, widthErr := strconv.Atoi(meta[originalWidthKey])
+	height, heightErr := strconv.Atoi(meta[originalHeightKey])
+	small, err := d.GetAnalysisImage(name)
+	switch {
+	case widthErr == nil && heightErr == nil && err == nil:
+		bg = background.FromAnalysis(small, width, height, name)
+	case cfg.Output == nil:
+		filename := backgroundFiles(cfg.OutputPath, name, meta)[0]
+		bg = background.FromFetcher(func() ([]byte, error) {
+			return ioutil.ReadFile(filename)
+		}, name)
+	default:
+		return nil, false
+	}
+
+	for key, val := range meta {
+		bg.AddMetadata(key, val)
+	}
+
+	return bg, true
+}
+
+// PrintReevaluation lists the active backgrounds the current filters reject, marking them stale unless dryRun is set
+func PrintReevaluation(c *BgFreshConfig, dryRun bool, out io.Writer) error {
+	cfg, err := config.Load(c.Config)
+	if err != nil {
+		return err
+	}
+
+	dbLog := c.Log.WithFields(logrus.Fields{
+		"section": "db",
+	})
+
+	var d db.BackgroundDb
+	if dryRun {
+		d, err = db.NewReadOnlyDb(cfg, dbLog)
+	} else {
+		d, err = db.NewDb(cfg, dbLog)
+	}
+	if err != nil {
+		return err
+	}
+	defer d.Stop()
+
+	pl, err := pipeline.NewPipeline(cfg, d, c.Log)
+	if err != nil {
+		return err
+	}
+
+	failed, err := reevaluateActive(cfg, d, pl, c.Log.WithFields(logrus.Fields{
+		"section": "reevaluate",
+	}), dryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(failed) == 0 {
+		_, err := fmt.Fprintln(out, "every active background passes the current filters")
+		return err
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "BACKGROUND\tSOURCE\tFILTER\tREASON")
+	for _, f := range failed {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", f.Name, f.Source, f.Filter, f.Reason)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	if dryRun {
+		_, err = fmt.Fprintf(out, "%d backgrounds would be rotated out\n", len(failed))
+	} else {
+		_, err = fmt.Fprintf(out, "%d backgrounds marked stale\n", len(failed))
+	}
+	return err
+}
@@ -5,18 +5,20 @@
 	"bgfreshd/internal/config"
 	"bgfreshd/internal/db"
 	_ "bgfreshd/internal/filters"
+	"bgfreshd/internal/output"
 	"bgfreshd/internal/pipeline"
+	_ "bgfreshd/internal/sinks"
 	_ "bgfreshd/internal/sources"
 	"bgfreshd/pkg/background"
-	"bufio"
+	"bgfreshd/pkg/sink"
 	"context"
-	"fmt"
 	"github.com/sirupsen/logrus"
-	"image/jpeg"
 	"os"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -34,6 +36,23 @@ type bgFreshService struct {
 	ctx               context.Context
 	cancel            context.CancelFunc
 	triggerGeneration chan int
+
+	// deferred holds candidates that broke a diversity rule, oldest first. their images are unloaded until
+	// they're picked, the diversity rules only need their metadata
+	deferred []background.Background
+
+	// sinks mirror the files written to the output path, sinkLock keeps syncing from racing writes to them
+	sinks    []sink.OutputSink
+	sinkLock sync.Mutex
+
+	// unsynced holds the sinks that may have drifted from the active backgrounds, every sink at startup
+	unsynced map[sink.OutputSink]bool
+
+	// caption is drawn over the written images, nil when there's no caption configured
+	caption *output.Caption
+
+	// changed is set when backgrounds are added or removed, until the rotation complete hooks run
+	changed bool
 }
 
 func (b *bgFreshService) Stop() {
@@ -65,6 +84,28 @@ func NewService(c *BgFreshConfig) (Service, error) {
 		return nil, err
 	}
 
+	sinkLog := c.Log.WithFields(logrus.Fields{
+		"section": "sink",
+	})
+	var sinks []sink.OutputSink
+	unsynced := map[sink.OutputSink]bool{}
+	for i := range cfg.Sinks {
+		s, err := pipeline.CreateSink(&cfg.Sinks[i], sinkLog)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+		unsynced[s] = true
+	}
+
+	// the caption's font is parsed once rather than for every background
+	var caption *output.Caption
+	if cfg.Output != nil && cfg.Output.Caption != nil {
+		if caption, err = output.NewCaption(cfg.Output.Caption); err != nil {
+			return nil, err
+		}
+	}
+
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 
@@ -78,6 +119,9 @@ func NewService(c *BgFreshConfig) (Service, error) {
 		ctx:               ctx,
 		cancel:            cancel,
 		triggerGeneration: make(chan int),
+		sinks:             sinks,
+		unsynced:          unsynced,
+		caption:           caption,
 	}
 
 	if err := service.init(); err != nil {
@@ -88,38 +132,71 @@ func NewService(c *BgFreshConfig) (Service, error) {
 }
 
 func (b *bgFreshService) init() error {
+	// writes interrupted by a crash leave their temp files behind
+	err := filepath.Walk(b.config.OutputPath, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasPrefix(info.Name(), internal.TempFilePrefix) {
+			return nil
+		}
+
+		b.logger.Infof("removing interrupted write %s", filename)
+		if err := os.Remove(filename); err != nil {
+			b.logger.Warnf("error removing %s: %s", filename, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// backgrounds accepted under older filters are replaced by the first refresh
+	failed, err := reevaluateActive(b.config, b.db, b.pipeline, b.logger, false)
+	if err != nil {
+		return err
+	}
+
+	if len(failed) != 0 {
+		b.logger.Infof("marked %d backgrounds that fail the current filters stale", len(failed))
+	}
+
+	b.updatePlaylists()
 	return nil
 }
 
 func (b *bgFreshService) Run() error {
 	go backgroundJob(b, time.Second*30, watchOutput)
+	go backgroundJob(b, time.Minute*5, syncSinks)
 	for {
 		stale, err := b.db.GetStaleBackgrounds()
 		if err != nil {
-			return err
+			return b.runErrorHooks(err)
 		}
 
 		if len(stale) != 0 {
 			if err := b.refreshStale(stale); err != nil {
-				return err
+				return b.runErrorHooks(err)
 			}
 		}
 
 		active, err := b.db.GetActiveBackgrounds()
 		if err != nil {
-			return err
+			return b.runErrorHooks(err)
 		}
 
 		for len(active) < b.config.MaxBackgrounds {
 			if err := b.loadOne(); err != nil {
-				return err
+				return b.runErrorHooks(err)
 			}
 
 			active, err = b.db.GetActiveBackgrounds()
 			if err != nil {
-				return err
+				return b.runErrorHooks(err)
 			}
 		}
+
+		if b.changed {
+			b.changed = false
+			b.runRotationCompleteHooks()
+		}
 		runtime.GC()
 		debug.FreeOSMemory()
 
@@ -133,7 +210,7 @@ func (b *bgFreshService) Run() error {
 }
 
 func (b *bgFreshService) loadOne() error {
-	bg, err := b.pipeline.LoadOne()
+	bg, err := b.nextCandidate()
 	if err != nil {
 		return err
 	}
@@ -145,6 +222,9 @@ func (b *bgFreshService) loadOne() error {
 		if err := b.createImage(bg); err != nil {
 			return err
 		}
+		b.changed = true
+		b.updatePlaylists()
+		b.runAddedHooks(name)
 	} else {
 		b.logger.Debugf("load attempt rejected for %s, already exists", name)
 	}
@@ -152,58 +232,274 @@ func (b *bgFreshService) loadOne() error {
 	return nil
 }
 
+// nextCandidate returns a deferred candidate that now fits the diversity rules,
+// or loads new candidates until one fits
+func (b *bgFreshService) nextCandidate() (background.Background, error) {
+	rules := b.config.Diversity
+	if rules == nil {
+		return b.pipeline.LoadOne()
+	}
+
+	active, err := b.activeSummaries()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(b.deferred); i++ {
+		candidate := b.deferred[i]
+		if violation := checkDiversity(rules, b.config.MaxBackgrounds, candidate, active); len(violation) != 0 {
+			continue
+		}
+
+		b.deferred = append(b.deferred[:i], b.deferred[i+1:]...)
+		i--
+		if b.reloadDeferred(candidate) {
+			b.logger.Infof("deferred candidate %s now fits", candidate.GetName())
+			return candidate, nil
+		}
+	}
+
+	for attempt := 0; attempt < maxDiversityAttempts; attempt++ {
+		bg, err := b.pipeline.LoadOne()
+		if err != nil {
+			return nil, err
+		}
+
+		// loadOne skips backgrounds that already exist, which would otherwise clash with themselves
+		if b.db.Exists(bg.GetName()) {
+			return bg, nil
+		}
+
+		violation := checkDiversity(rules, b.config.MaxBackgrounds, bg, active)
+		if len(violation) == 0 {
+			return bg, nil
+		}
+
+		b.logger.Infof("deferring %s: %s", bg.GetName(), violation)
+		b.deferCandidate(bg)
+	}
+
+	// the sources can't currently satisfy the rules, so the longest waiting candidate goes in anyway
+	for len(b.deferred) != 0 {
+		oldest := b.deferred[0]
+		b.deferred = b.deferred[1:]
+		if b.reloadDeferred(oldest) {
+			b.logger.Warnf("no candidate fits the diversity rules after %d attempts, using deferred candidate %s", maxDiversityAttempts, oldest.GetName())
+			return oldest, nil
+		}
+	}
+
+	b.logger.Warnf("no candidate fits the diversity rules after %d attempts and none that were deferred can be loaded, ignoring the rules", maxDiversityAttempts)
+	return b.pipeline.LoadOne()
+}
+
+// reloadDeferred loads the image of a deferred candidate again, a candidate whose image has since gone is dropped
+func (b *bgFreshService) reloadDeferred(bg background.Background) bool {
+	if err := bg.Load(); err != nil {
+		b.logger.Warnf("dropping deferred candidate %s, its image can't be loaded again: %s", bg.GetName(), err.Error())
+		return false
+	}
+
+	return true
+}
+
+func (b *bgFreshService) deferCandidate(bg background.Background) {
+	for _, deferred := range b.deferred {
+		if deferred.GetName() == bg.GetName() {
+			return
+		}
+	}
+
+	// its look is already described in its metadata, the image is fetched again if it's picked
+	bg.Unload()
+	b.deferred = append(b.deferred, bg)
+	if len(b.deferred) > b.config.Diversity.MaxDeferred {
+		b.logger.Infof("dropping deferred candidate %s", b.deferred[0].GetName())
+		b.deferred = b.deferred[1:]
+	}
+}
+
+// activeSummaries describes the active backgrounds that aren't about to be replaced
+func (b *bgFreshService) activeSummaries() ([]activeSummary, error) {
+	active, err := b.db.GetActiveBackgrounds()
+	if err != nil {
+		return nil, err
+	}
+
+	stale, err := b.db.GetStaleBackgrounds()
+	if err != nil {
+		return nil, err
+	}
+
+	leaving := map[string]bool{}
+	for _, name := range stale {
+		leaving[name] = true
+	}
+
+	summaries := make([]activeSummary, 0, len(active))
+	for _, name := range active {
+		if leaving[name] {
+			continue
+		}
+
+		meta, err := b.db.GetMetadata(name)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summarize(name, meta))
+	}
+
+	return summaries, nil
+}
+
 func (b *bgFreshService) createImage(bg background.Background) error {
+	describeLook(bg)
+	if err := recordOriginal(bg); err != nil {
+		return err
+	}
+
+	rendered, err := render(b.config, b.caption, bg)
+	if err != nil {
+		return err
+	}
+	if err := encode(b.config, bg, rendered, b.logger); err != nil {
+		return err
+	}
+	if err := nameFiles(b.config, bg, rendered); err != nil {
+		return err
+	}
+	recordFiles(bg, rendered)
+	for _, r := range rendered {
+		if _, err := os.Stat(r.filename); !os.IsNotExist(err) {
+			return os.ErrExist
+		}
+	}
+
 	bg.SetActive()
 	bg.GenerateExpiry(b.config.MinRotationAge, b.config.MaxRotationAge)
 	if err := b.db.SaveMetadata(bg); err != nil {
 		return err
 	}
 
-	filename := b.getFilename(bg.GetName())
-	if _, err := os.Stat(filename); !os.IsNotExist(err) {
-		return os.ErrExist
+	for _, r := range rendered {
+		if err := b.writeFile(r.filename, r.encoded); err != nil {
+			return err
+		}
+
+		if b.config.Output != nil && b.config.Output.Sidecar {
+			encoded, err := sidecar(bg, r.filename)
+			if err != nil {
+				return err
+			}
+			if err := b.writeFile(sidecarFilename(r.filename), encoded); err != nil {
+				return err
+			}
+		}
 	}
 
-	file, err := os.Create(filename)
-	if err != nil {
+	return nil
+}
+
+// writeFile writes a file to the output path without exposing it half written, then mirrors it to the sinks.
+// sinks failing is logged rather than stopping the rotation, they're brought back in line by syncSinks
+func (b *bgFreshService) writeFile(filename string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
 		return err
 	}
-	defer internal.Deferrer(b.logger, file.Close)
 
-	writer := bufio.NewWriter(file)
-	if err := jpeg.Encode(writer, bg.GetImage(), &jpeg.Options{Quality: 100}); err != nil {
+	if err := internal.WriteFileAtomic(filename, data, 0644); err != nil {
 		return err
 	}
-	if err := writer.Flush(); err != nil {
+
+	b.sinkLock.Lock()
+	defer b.sinkLock.Unlock()
+
+	name := b.sinkName(filename)
+	for _, s := range b.sinks {
+		if err := b.putSinkFile(s, name, data); err != nil {
+			b.logger.Warnf("error mirroring %s to sink %s: %s", name, s.GetName(), err.Error())
+			b.unsynced[s] = true
+		}
+	}
+
+	return nil
+}
+
+// removeFile removes a file from the output path and the sinks, already missing files aren't an error
+func (b *bgFreshService) removeFile(filename string) error {
+	b.sinkLock.Lock()
+	name := b.sinkName(filename)
+	for _, s := range b.sinks {
+		if err := b.removeSinkFile(s, name); err != nil {
+			b.logger.Warnf("error removing %s from sink %s: %s", name, s.GetName(), err.Error())
+			b.unsynced[s] = true
+		}
+	}
+	b.sinkLock.Unlock()
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
+	// subfolders are left behind only while they still hold files
+	for dir := filepath.Dir(filename); dir != filepath.Clean(b.config.OutputPath) && strings.HasPrefix(dir, filepath.Clean(b.config.OutputPath)); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+
 	return nil
 }
 
+// sinkName is the slash separated path of a file relative to the output path
+func (b *bgFreshService) sinkName(filename string) string {
+	rel, err := filepath.Rel(b.config.OutputPath, filename)
+	if err != nil {
+		return filepath.Base(filename)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
 func (b *bgFreshService) refreshStale(stale []string) error {
 	for _, val := range stale {
 		if err := b.loadOne(); err != nil {
 			return err
 		}
 
+		removedEnv := b.removedHookEnv(val)
 		b.logger.Infof("marking %s inactive", val)
 		if err := b.db.MarkInactive(val); err != nil {
 			return err
 		}
 
 		b.logger.Infof("removing %s", val)
-		filename := b.getFilename(val)
-		if err := os.Remove(filename); err != nil {
-			b.logger.Warnf("error removing %s: %s", val, err.Error())
+		for _, filename := range b.getFiles(val) {
+			if err := b.removeFile(filename); err != nil {
+				b.logger.Warnf("error removing %s: %s", val, err.Error())
+			}
+
+			// sidecars are removed whether or not they're still configured
+			if err := b.removeFile(sidecarFilename(filename)); err != nil {
+				b.logger.Warnf("error removing sidecar of %s: %s", val, err.Error())
+			}
 		}
+		b.changed = true
+		b.updatePlaylists()
+		b.runRemovedHooks(removedEnv)
 	}
 
 	return nil
 }
 
-func (b *bgFreshService) getFilename(bgName string) string {
-	return filepath.Join(b.config.OutputPath, fmt.Sprintf("%s.jpeg", bgName))
+// getFiles returns the files written for a background
+func (b *bgFreshService) getFiles(bgName string) []string {
+	meta, err := b.db.GetMetadata(bgName)
+	if err != nil {
+		b.logger.Warnf("error reading metadata of %s: %s", bgName, err.Error())
+	}
+
+	return backgroundFiles(b.config.OutputPath, bgName, meta)
 }
 
 func backgroundJob(b *bgFreshService, occursEvery time.Duration, job func(b *bgFreshService)) {
@@ -226,14 +522,15 @@ func watchOutput(b *bgFreshService) {
 
 	anyMarked := false
 	for _, name := range active {
-		filename := b.getFilename(name)
-		if _, err := os.Stat(filename); os.IsNotExist(err) {
-			b.logger.Infof("background %s file missing - marking stale", name)
-			err := b.db.MarkStale(name)
-			anyMarked = true
-			if err != nil {
-				b.logger.Warnf("watch output mark stale error: %s", err.Error())
-				continue
+		for _, filename := range b.getFiles(name) {
+			if _, err := os.Stat(filename); os.IsNotExist(err) {
+				b.logger.Infof("background %s file missing - marking stale", name)
+				err := b.db.MarkStale(name)
+				anyMarked = true
+				if err != nil {
+					b.logger.Warnf("watch output mark stale error: %s", err.Error())
+				}
+				break
 			}
 		}
 	}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bgfreshd/internal/config"
+	"bgfreshd/internal/db"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// PrintStats writes the per source and per filter accept and reject counts
+func PrintStats(c *BgFreshConfig, out io.Writer) error {
+	cfg, err := config.Load(c.Config)
+	if err != nil {
+		return err
+	}
+
+	d, err := db.NewReadOnlyDb(cfg, c.Log.WithFields(logrus.Fields{
+		"section": "db",
+	}))
+	if err != nil {
+		return err
+	}
+	defer d.Stop()
+
+	stats, err := d.GetFilterStats()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Source != stats[j].Source {
+			return stats[i].Source < stats[j].Source
+		}
+		return stats[i].Filter < stats[j].Filter
+	})
+
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "SOURCE\tFILTER\tACCEPTED\tREJECTED\tREJECT RATE")
+	for _, stat := range stats {
+		rate := 0.0
+		if total := stat.Accepted + stat.Rejected; total != 0 {
+			rate = 100 * float64(stat.Rejected) / float64(total)
+		}
+
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%.1f%%\n", stat.Source, stat.Filter, stat.Accepted, stat.Rejected, rate)
+	}
+
+	return writer.Flush()
+}
@@ -3,12 +3,15 @@ module bgfreshd
 go 1.14
 
 require (
+	github.com/antonmedv/expr v1.8.9
 	github.com/goccy/go-yaml v1.4.3
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/mroth/weightedrand v0.2.1
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
 	github.com/sirupsen/logrus v1.6.0
 	github.com/urfave/cli/v2 v2.2.0
 	go.etcd.io/bbolt v1.3.4
+	golang.org/x/image v0.0.0-20200927104501-e162460cd6b5
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
@@ -1,12 +1,18 @@
 package config
 
 import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/output"
 	"bgfreshd/pkg/filter"
+	"bgfreshd/pkg/sink"
 	"bgfreshd/pkg/source"
 	"errors"
 	"fmt"
 	"github.com/goccy/go-yaml"
 	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // Config is the main configuration format
@@ -17,6 +23,181 @@ type Config struct {
 	MaxRotationAge int                    `yaml:"maxRotationAge"`
 	Sources        []source.Configuration `yaml:"sources"`
 	Filters        []filter.Configuration `yaml:"filters"`
+	Tournament     *TournamentConfig      `yaml:"tournament,omitempty"`
+	Diversity      *DiversityConfig       `yaml:"diversity,omitempty"`
+	Output         *OutputConfig          `yaml:"output,omitempty"`
+	Sinks          []sink.Configuration   `yaml:"sinks"`
+	Playlist       *PlaylistConfig        `yaml:"playlist,omitempty"`
+	Hooks          *HooksConfig           `yaml:"hooks,omitempty"`
+}
+
+// TournamentConfig enables picking the best scoring of several candidates instead of the first valid one
+type TournamentConfig struct {
+	// Candidates is the amount of valid candidates gathered before picking
+	Candidates int `yaml:"candidates"`
+}
+
+// DiversityConfig keeps the active backgrounds from crowding around one source, author or look
+// candidates that break a rule are deferred until they fit
+type DiversityConfig struct {
+	// MaxSourceShare is the largest fraction of maxBackgrounds a single source may fill
+	MaxSourceShare float64 `yaml:"maxSourceShare"`
+
+	// MaxPerAuthor is the most active backgrounds a single author may have
+	MaxPerAuthor int `yaml:"maxPerAuthor"`
+
+	// MinColorDistance is the smallest delta E allowed between the average colors of two active backgrounds
+	MinColorDistance float64 `yaml:"minColorDistance"`
+
+	// MinPerceptualDistance is the fewest of the 64 perceptual hash bits two active backgrounds must differ by
+	MinPerceptualDistance int `yaml:"minPerceptualDistance"`
+
+	// MaxDeferred is how many candidates breaking a rule are held on to, defaulting to 5
+	MaxDeferred int `yaml:"maxDeferred"`
+}
+
+// OutputConfig describes how backgrounds are prepared before they're written to the output path
+type OutputConfig struct {
+	// Width and Height are the resolution backgrounds are cropped and scaled to, unset keeps the source resolution
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
+
+	// Crop picks what the crop is anchored on, entropy, saliency or center, defaulting to entropy
+	Crop string `yaml:"crop"`
+
+	// Monitors describes a multi-monitor layout, used instead of width and height
+	Monitors []MonitorConfig `yaml:"monitors"`
+
+	// Layout is either span, a single image covering every monitor, or perMonitor,
+	// a matched crop for each monitor cut from the same image. defaults to span
+	Layout string `yaml:"layout"`
+
+	// MaxUpscale rejects candidates that would need to be enlarged by more than this factor to cover the output
+	MaxUpscale float64 `yaml:"maxUpscale"`
+
+	// Format is the encoding written, original keeps the source's bytes when the image isn't changed,
+	// or jpeg, png or webp. defaults to original
+	Format string `yaml:"format"`
+
+	// Quality is the jpeg quality used when re-encoding, defaulting to 100
+	Quality int `yaml:"quality"`
+
+	// Transforms are applied in order to the written image
+	Transforms []output.TransformConfiguration `yaml:"transforms"`
+
+	// Variants each write a derived copy next to every written image, IE a blurred lock screen
+	Variants []VariantConfig `yaml:"variants"`
+
+	// Caption draws attribution over the written images after the transforms, variants are left without it
+	Caption *output.CaptionOptions `yaml:"caption"`
+
+	// Sidecar writes the background's metadata as json next to every written image, IE <name>.json
+	Sidecar bool `yaml:"sidecar"`
+
+	// EmbedMetadata writes the title, author and source into jpegs as XMP and EXIF
+	EmbedMetadata bool `yaml:"embedMetadata"`
+
+	// Filename is a template for the name of written files without their extension, IE {{.source}}-{{.slug}},
+	// defaulting to the background's name. monitor and variant names are appended to it
+	Filename string `yaml:"filename"`
+
+	// Folder places files in subfolders of the output path, source, tag or a template, unset writes to the output path
+	Folder string `yaml:"folder"`
+}
+
+// VariantConfig is a derived copy of the output, transformed from the cropped image without the output's own transforms
+type VariantConfig struct {
+	// Name is appended to the filename of the image the variant is derived from
+	Name       string                          `yaml:"name"`
+	Transforms []output.TransformConfiguration `yaml:"transforms"`
+}
+
+// PlaylistConfig writes the active backgrounds as files desktops can cycle through themselves, regenerated
+// whenever the active set changes. relative paths are within the output path
+type PlaylistConfig struct {
+	// Gnome is where a GNOME background slideshow xml is written, IE slideshow.xml
+	Gnome string `yaml:"gnome"`
+
+	// Duration is how many seconds each background is shown in the slideshow, defaulting to 1800
+	Duration float64 `yaml:"duration"`
+
+	// Transition is how many seconds the slideshow fades between backgrounds, defaulting to 5
+	Transition float64 `yaml:"transition"`
+
+	// List is where a newline separated list of the background files is written, IE backgrounds.list
+	List string `yaml:"list"`
+
+	// Current is where a symlink to a single background is kept, IE current
+	Current string `yaml:"current"`
+
+	// CurrentPick chooses the background current links to, newest, oldest or random, defaulting to newest
+	CurrentPick string `yaml:"currentPick"`
+
+	// Variant lists the files of an output variant instead of the main image
+	Variant string `yaml:"variant"`
+}
+
+// current picks
+const (
+	PickNewest = "newest"
+	PickOldest = "oldest"
+	PickRandom = "random"
+)
+
+// HooksConfig runs commands as the active backgrounds change, IE to set the wallpaper with feh or swww
+type HooksConfig struct {
+	// OnAdded runs once the files of a new background are written
+	OnAdded []HookConfig `yaml:"onAdded"`
+
+	// OnRemoved runs once the files of a rotated out background are removed
+	OnRemoved []HookConfig `yaml:"onRemoved"`
+
+	// OnRotationComplete runs after a round of rotation that changed the active backgrounds, with the newest
+	OnRotationComplete []HookConfig `yaml:"onRotationComplete"`
+
+	// OnError runs when rotation stops on an error
+	OnError []HookConfig `yaml:"onError"`
+}
+
+// HookConfig is a single command, run with sh -c
+type HookConfig struct {
+	Command string `yaml:"command"`
+
+	// Timeout in seconds before the command is killed, defaulting to 30
+	Timeout int `yaml:"timeout"`
+}
+
+// MonitorConfig is a single display of a multi-monitor layout, positioned in desktop pixels
+type MonitorConfig struct {
+	Name   string `yaml:"name"`
+	Width  int    `yaml:"width"`
+	Height int    `yaml:"height"`
+	X      int    `yaml:"x"`
+	Y      int    `yaml:"y"`
+}
+
+// output layouts
+const (
+	LayoutSpan       = "span"
+	LayoutPerMonitor = "perMonitor"
+)
+
+// Size returns the resolution the output covers, the bounding box of the monitors when a layout is set
+func (o *OutputConfig) Size() (int, int) {
+	if len(o.Monitors) == 0 {
+		return o.Width, o.Height
+	}
+
+	minX, minY := o.Monitors[0].X, o.Monitors[0].Y
+	maxX, maxY := minX+o.Monitors[0].Width, minY+o.Monitors[0].Height
+	for _, m := range o.Monitors[1:] {
+		minX = minInt(minX, m.X)
+		minY = minInt(minY, m.Y)
+		maxX = maxInt(maxX, m.X+m.Width)
+		maxY = maxInt(maxY, m.Y+m.Height)
+	}
+
+	return maxX - minX, maxY - minY
 }
 
 // Load loads the config at the given path
@@ -56,14 +237,290 @@ func validate(config *Config) error {
 		}
 	}
 
+	if config.Tournament != nil && config.Tournament.Candidates < 1 {
+		return errors.New("Tournament candidates must be at least 1")
+	}
+
+	if config.Output != nil {
+		if err := validateOutput(config.Output); err != nil {
+			return err
+		}
+	}
+
+	if config.Diversity != nil {
+		if err := validateDiversity(config.Diversity); err != nil {
+			return err
+		}
+	}
+
+	if config.Playlist != nil {
+		if err := validatePlaylist(config.Playlist, config.Output); err != nil {
+			return err
+		}
+	}
+
+	if config.Hooks != nil {
+		if err := validateHooks(config.Hooks); err != nil {
+			return err
+		}
+	}
+
+	if err := validateSinks(config); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// localSinkOptions is the part of a local sink's options validated here
+type localSinkOptions struct {
+	Path string `yaml:"path"`
+}
+
+func validateSinks(config *Config) error {
+	outputPath, err := filepath.Abs(config.OutputPath)
+	if err != nil {
+		return err
+	}
+
+	// the files a sink was written are tracked by its name
+	names := map[string]bool{}
+	for i, conf := range config.Sinks {
+		if len(conf.Type) == 0 {
+			return fmt.Errorf("Sink %d must have a type", i)
+		}
+
+		name := conf.Name
+		if len(name) == 0 {
+			name = conf.Type
+		}
+		if names[name] {
+			return fmt.Errorf("Sink name %s is used more than once, name each sink of the same type", name)
+		}
+		names[name] = true
+
+		if conf.Type != "local" {
+			continue
+		}
+
+		var options localSinkOptions
+		if err := internal.CastDecodedYamlToType(conf.Options, &options); err != nil {
+			return err
+		}
+		if len(options.Path) == 0 {
+			continue
+		}
+
+		sinkPath, err := filepath.Abs(options.Path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(outputPath, sinkPath)
+		if err == nil && (rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))) {
+			return fmt.Errorf("Sink %s path must not be within outputPath", name)
+		}
+	}
+
+	return nil
+}
+
+func validateDiversity(config *DiversityConfig) error {
+	if config.MaxSourceShare < 0 || config.MaxSourceShare > 1 {
+		return errors.New("Diversity maxSourceShare must be between 0 and 1")
+	}
+
+	if config.MaxPerAuthor < 0 || config.MinColorDistance < 0 || config.MinPerceptualDistance < 0 || config.MaxDeferred < 0 {
+		return errors.New("Diversity limits must not be negative")
+	}
+
+	if config.MaxDeferred == 0 {
+		config.MaxDeferred = 5
+	}
+
+	return nil
+}
+
+func validateOutput(config *OutputConfig) error {
+	if config.Width < 0 || config.Height < 0 || (config.Width == 0) != (config.Height == 0) {
+		return errors.New("Output width and height must both be set to a positive resolution")
+	}
+
+	if len(config.Monitors) != 0 && config.Width != 0 {
+		return errors.New("Output width and height can't be combined with monitors")
+	}
+
+	names := map[string]bool{}
+	for i := range config.Monitors {
+		monitor := &config.Monitors[i]
+		if monitor.Width <= 0 || monitor.Height <= 0 {
+			return fmt.Errorf("Output monitor %d must have a positive width and height", i)
+		}
+
+		if len(monitor.Name) == 0 {
+			monitor.Name = strconv.Itoa(i)
+		}
+		if !usableInFilename(monitor.Name) {
+			return fmt.Errorf("Output monitor %d needs a name that can be used in a filename", i)
+		}
+		if names[monitor.Name] {
+			return fmt.Errorf("Output monitor name %s is used more than once", monitor.Name)
+		}
+		names[monitor.Name] = true
+	}
+
+	switch config.Layout {
+	case "":
+		config.Layout = LayoutSpan
+	case LayoutSpan, LayoutPerMonitor:
+	default:
+		return fmt.Errorf("Output layout \"%s\" is unknown, expected span or perMonitor", config.Layout)
+	}
+
+	if config.MaxUpscale < 0 {
+		return errors.New("Output maxUpscale must not be negative")
+	}
+
+	switch config.Crop {
+	case "":
+		config.Crop = "entropy"
+	case "entropy", "saliency", "center":
+	default:
+		return fmt.Errorf("Output crop \"%s\" is unknown, expected entropy, saliency or center", config.Crop)
+	}
+
+	switch config.Format {
+	case "":
+		config.Format = "original"
+	case "original", "jpeg", "png", "webp":
+	default:
+		return fmt.Errorf("Output format \"%s\" is unknown, expected original, jpeg, png or webp", config.Format)
+	}
+
+	if config.Quality < 0 || config.Quality > 100 {
+		return errors.New("Output quality must be between 1 and 100, or unset for 100")
+	}
+
+	// default vals if unset
+	if config.Quality == 0 {
+		config.Quality = 100
+	}
+
+	if _, err := output.NewTransforms(config.Transforms); err != nil {
+		return fmt.Errorf("Output %s", err.Error())
+	}
+
+	if _, err := output.NewNamer(config.Filename, config.Folder); err != nil {
+		return fmt.Errorf("Output %s", err.Error())
+	}
+
+	if config.Caption != nil {
+		if _, err := output.NewCaption(config.Caption); err != nil {
+			return fmt.Errorf("Output %s", err.Error())
+		}
+	}
+
+	variants := map[string]bool{}
+	for _, variant := range config.Variants {
+		if len(variant.Name) == 0 || !usableInFilename(variant.Name) {
+			return errors.New("Output variants need a name that can be used in a filename")
+		}
+		if variants[variant.Name] {
+			return fmt.Errorf("Output variant name %s is used more than once", variant.Name)
+		}
+		variants[variant.Name] = true
+
+		if _, err := output.NewTransforms(variant.Transforms); err != nil {
+			return fmt.Errorf("Output variant %s %s", variant.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// maxSuffixNameLength bounds monitor and variant names, which are appended to filenames, so the rest of the
+// filename keeps room within filesystem limits
+const maxSuffixNameLength = 64
+
+// usableInFilename reports whether a monitor or variant name can be appended to a filename as it is
+func usableInFilename(name string) bool {
+	return len(name) <= maxSuffixNameLength && !strings.ContainsAny(name, "/\\,") && !strings.Contains(name, "..")
+}
+
+func validatePlaylist(config *PlaylistConfig, outputConfig *OutputConfig) error {
+	if config.Duration < 0 || config.Transition < 0 {
+		return errors.New("Playlist duration and transition must not be negative")
+	}
+
+	// default vals if unset
+	if config.Duration == 0 {
+		config.Duration = 1800
+	}
+	if config.Transition == 0 {
+		config.Transition = 5
+	}
+
+	if config.Transition >= config.Duration {
+		return errors.New("Playlist transition must be shorter than the duration")
+	}
+
+	switch config.CurrentPick {
+	case "":
+		config.CurrentPick = PickNewest
+	case PickNewest, PickOldest, PickRandom:
+	default:
+		return fmt.Errorf("Playlist currentPick \"%s\" is unknown, expected newest, oldest or random", config.CurrentPick)
+	}
+
+	if len(config.Variant) != 0 {
+		found := false
+		if outputConfig != nil {
+			for _, variant := range outputConfig.Variants {
+				found = found || variant.Name == config.Variant
+			}
+		}
+		if !found {
+			return fmt.Errorf("Playlist variant %s isn't an output variant", config.Variant)
+		}
+	}
+
+	return nil
+}
+
+func validateHooks(config *HooksConfig) error {
+	for _, hooks := range [][]HookConfig{config.OnAdded, config.OnRemoved, config.OnRotationComplete, config.OnError} {
+		for i := range hooks {
+			hook := &hooks[i]
+			if len(strings.TrimSpace(hook.Command)) == 0 {
+				return errors.New("Hooks must have a command")
+			}
+			if hook.Timeout < 0 {
+				return errors.New("Hook timeouts must not be negative")
+			}
+
+			// default val if unset
+			if hook.Timeout == 0 {
+				hook.Timeout = 30
+			}
+		}
+	}
+
 	return nil
 }
 
 func validateFilter(config *filter.Configuration) error {
+	if config.Weight != nil && *config.Weight < 0 {
+		return fmt.Errorf("filter %s weight must not be negative", config.Type)
+	}
+
 	return nil
 }
 
 func validateSource(config *source.Configuration) error {
+	for i, _ := range config.Filters {
+		if err := validateFilter(&config.Filters[i]); err != nil {
+			return err
+		}
+	}
 
 	if config.Weight == nil {
 		config.Weight = new(uint)
@@ -72,3 +529,17 @@ func validateSource(config *source.Configuration) error {
 
 	return nil
 }
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -2,12 +2,16 @@
 
 import (
 	"bgfreshd/internal/config"
+	"bgfreshd/pkg"
 	"bgfreshd/pkg/background"
 	"bgfreshd/pkg/source"
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
+	"image"
+	"image/png"
 	"os"
 	"path/filepath"
 	"time"
@@ -16,6 +20,10 @@
 type BackgroundDb interface {
 	Stop()
 	SaveMetadata(bg background.Background) error
+	GetMetadata(name string) (map[string]string, error)
+	SetMetadata(name string, key string, val string) error
+	GetCreatedDate(name string) (time.Time, error)
+	GetAnalysisImage(name string) (image.Image, error)
 	GetBackgroundList() ([]string, error)
 	GetActiveBackgrounds() ([]string, error)
 	GetStaleBackgrounds() ([]string, error)
@@ -23,6 +31,14 @@ type BackgroundDb interface {
 	MarkStale(name string) error
 	Exists(key string) bool
 	NewSourceDb(sourceName string, sourceMeta string) (source.Db, error)
+	RecordFilterResults(sourceName string, results []FilterResult) error
+	GetFilterStats() ([]FilterStats, error)
+	RecordRejection(sourceName string, keys []string, record RejectionRecord) error
+	GetRejection(sourceName string, keys []string) (*RejectionRecord, error)
+	PruneRejections(sourceName string, fingerprints map[string]bool) (int, error)
+	RecordSinkFile(sinkName string, name string) error
+	ForgetSinkFile(sinkName string, name string) error
+	GetSinkFiles(sinkName string) (map[string]bool, error)
 }
 
 type backgroundDb struct {
@@ -77,6 +93,17 @@ func (b *backgroundDb) SaveMetadata(bg background.Background) error {
 		return err
 	}
 
+	// filters judge the background by this again once only its cropped and transformed files are left
+	if analysis := bg.GetAnalysis(); analysis != nil {
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, analysis.Image()); err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte("analysis_image"), encoded.Bytes()); err != nil {
+			return err
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		return err
 	}
@@ -84,6 +111,88 @@ func (b *backgroundDb) SaveMetadata(bg background.Background) error {
 	return nil
 }
 
+// reservedKeys are the keys SaveMetadata writes alongside a background's metadata
+var reservedKeys = map[string]bool{
+	"name":           true,
+	"created_date":   true,
+	"expires_on":     true,
+	"active":         true,
+	"stale":          true,
+	"analysis_image": true,
+}
+
+// GetMetadata returns the metadata a background was saved with
+func (b *backgroundDb) GetMetadata(name string) (map[string]string, error) {
+	meta := map[string]string{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(name))
+		if bucket == nil {
+			return &pkg.NotFoundError{Key: name}
+		}
+
+		return bucket.ForEach(func(key []byte, val []byte) error {
+			if !reservedKeys[string(key)] && val != nil {
+				meta[string(key)] = string(val)
+			}
+			return nil
+		})
+	})
+	return meta, err
+}
+
+// SetMetadata updates a single metadata key of a saved background
+func (b *backgroundDb) SetMetadata(name string, key string, val string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(name))
+		if bucket == nil {
+			return &pkg.NotFoundError{Key: name}
+		}
+
+		return b.putString(bucket, key, val)
+	})
+}
+
+// GetCreatedDate returns when a saved background was first found
+func (b *backgroundDb) GetCreatedDate(name string) (time.Time, error) {
+	var created time.Time
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(name))
+		if bucket == nil {
+			return &pkg.NotFoundError{Key: name}
+		}
+
+		var err error
+		created, err = b.getTime(bucket, "created_date")
+		return err
+	})
+	return created, err
+}
+
+// GetAnalysisImage returns the downscaled copy of the image a background was accepted with
+func (b *backgroundDb) GetAnalysisImage(name string) (image.Image, error) {
+	var encoded []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(name))
+		if bucket == nil {
+			return &pkg.NotFoundError{Key: name}
+		}
+
+		val := bucket.Get([]byte("analysis_image"))
+		if val == nil {
+			return &pkg.NotFoundError{Key: "analysis_image"}
+		}
+
+		// bolt's values are only valid during the transaction
+		encoded = append([]byte{}, val...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return png.Decode(bytes.NewReader(encoded))
+}
+
 func (b *backgroundDb) GetBackgroundList() ([]string, error) {
 	var names []string
 	err := b.db.View(func(tx *bolt.Tx) error {
@@ -193,6 +302,39 @@ func NewDb(config *config.Config, logger *logrus.Entry) (BackgroundDb, error) {
 	return ret, nil
 }
 
+type DbLockedError struct {
+	Path string
+}
+
+func (d DbLockedError) Error() string {
+	return fmt.Sprintf("database %s is locked, stop the running bgfreshd service and try again", d.Path)
+}
+
+// NewReadOnlyDb opens the database for inspection without starting any background jobs
+// bolt holds an exclusive lock on the file, so this fails while the service is running
+func NewReadOnlyDb(config *config.Config, logger *logrus.Entry) (BackgroundDb, error) {
+	dbFile := filepath.Join(config.OutputPath, ".bgfreshd.dat")
+	dbOpts := &bolt.Options{
+		Timeout:  5 * time.Second,
+		ReadOnly: true,
+	}
+	db, err := bolt.Open(dbFile, 0664, dbOpts)
+	if err == bolt.ErrTimeout {
+		return nil, &DbLockedError{Path: dbFile}
+	} else if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backgroundDb{
+		config: config,
+		logger: logger,
+		db:     db,
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
 func dbBackgroundJob(bgDb *backgroundDb, occursEvery time.Duration, job func(db *backgroundDb)) {
 	for {
 		job(bgDb)
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// rejectionsBucket holds a nested bucket per source of the candidates its filters rejected,
+// keyed by every key the candidate is known by, such as its name and url
+const rejectionsBucket = ".rejections"
+
+// RejectionRecord remembers which filter rejected a candidate, along with the fingerprint
+// of that filter's configuration at the time
+type RejectionRecord struct {
+	Filter      string    `json:"filter"`
+	Fingerprint string    `json:"fingerprint"`
+	Reason      string    `json:"reason"`
+	RejectedOn  time.Time `json:"rejectedOn"`
+}
+
+func (b *backgroundDb) RecordRejection(sourceName string, keys []string, record RejectionRecord) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		rejections, err := tx.CreateBucketIfNotExists([]byte(rejectionsBucket))
+		if err != nil {
+			return err
+		}
+
+		bucket, err := rejections.CreateBucketIfNotExists([]byte(sourceName))
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := bucket.Put([]byte(key), encoded); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetRejection returns the record of the first key that was rejected before, or nil if none were
+func (b *backgroundDb) GetRejection(sourceName string, keys []string) (*RejectionRecord, error) {
+	var found *RejectionRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := sourceRejections(tx, sourceName)
+		if bucket == nil {
+			return nil
+		}
+
+		for _, key := range keys {
+			val := bucket.Get([]byte(key))
+			if val == nil {
+				continue
+			}
+
+			var record RejectionRecord
+			if err := json.Unmarshal(val, &record); err != nil {
+				return err
+			}
+
+			found = &record
+			return nil
+		}
+
+		return nil
+	})
+	return found, err
+}
+
+// PruneRejections forgets every rejection of a source whose fingerprint isn't in the given set,
+// returning how many entries were removed
+func (b *backgroundDb) PruneRejections(sourceName string, fingerprints map[string]bool) (int, error) {
+	// a read only database is only being inspected, pruning is left to the service
+	if b.db.IsReadOnly() {
+		return 0, nil
+	}
+
+	pruned := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := sourceRejections(tx, sourceName)
+		if bucket == nil {
+			return nil
+		}
+
+		var stale [][]byte
+		err := bucket.ForEach(func(key []byte, val []byte) error {
+			var record RejectionRecord
+			if err := json.Unmarshal(val, &record); err != nil || !fingerprints[record.Fingerprint] {
+				stale = append(stale, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// bolt doesn't allow modifying a bucket while iterating it
+		for _, key := range stale {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		pruned = len(stale)
+		return nil
+	})
+	return pruned, err
+}
+
+func sourceRejections(tx *bolt.Tx, sourceName string) *bolt.Bucket {
+	rejections := tx.Bucket([]byte(rejectionsBucket))
+	if rejections == nil {
+		return nil
+	}
+
+	return rejections.Bucket([]byte(sourceName))
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+// sinkFilesBucket holds a nested bucket per sink of the names written to it, so syncing only ever
+// removes files bgfreshd put there
+const sinkFilesBucket = ".sink-files"
+
+// RecordSinkFile remembers that name is being written to a sink. it's recorded before the write, a write
+// that fails after reaching the sink still leaves a file to clean up
+func (b *backgroundDb) RecordSinkFile(sinkName string, name string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		sinkFiles, err := tx.CreateBucketIfNotExists([]byte(sinkFilesBucket))
+		if err != nil {
+			return err
+		}
+
+		bucket, err := sinkFiles.CreateBucketIfNotExists([]byte(sinkName))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(name), []byte{})
+	})
+}
+
+// ForgetSinkFile forgets a name once it's been removed from a sink
+func (b *backgroundDb) ForgetSinkFile(sinkName string, name string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := sinkFiles(tx, sinkName)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete([]byte(name))
+	})
+}
+
+// GetSinkFiles returns every name written to a sink that hasn't since been removed
+func (b *backgroundDb) GetSinkFiles(sinkName string) (map[string]bool, error) {
+	names := map[string]bool{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := sinkFiles(tx, sinkName)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key []byte, _ []byte) error {
+			names[string(key)] = true
+			return nil
+		})
+	})
+	return names, err
+}
+
+func sinkFiles(tx *bolt.Tx, sinkName string) *bolt.Bucket {
+	sinkFiles := tx.Bucket([]byte(sinkFilesBucket))
+	if sinkFiles == nil {
+		return nil
+	}
+
+	return sinkFiles.Bucket([]byte(sinkName))
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"encoding/binary"
+	bolt "go.etcd.io/bbolt"
+)
+
+// filterStatsBucket holds a nested bucket per source, keyed by filter name
+const filterStatsBucket = ".filter-stats"
+
+// FilterStats counts how often a filter accepted or rejected candidates of a source
+type FilterStats struct {
+	Source   string
+	Filter   string
+	Accepted uint64
+	Rejected uint64
+}
+
+// FilterResult is a single filter's verdict on a candidate
+type FilterResult struct {
+	Filter   string
+	Accepted bool
+}
+
+// RecordFilterResults counts the verdicts a candidate was given, in a single transaction
+func (b *backgroundDb) RecordFilterResults(sourceName string, results []FilterResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		statsBucket, err := tx.CreateBucketIfNotExists([]byte(filterStatsBucket))
+		if err != nil {
+			return err
+		}
+
+		bucket, err := statsBucket.CreateBucketIfNotExists([]byte(sourceName))
+		if err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			stats := decodeFilterStats(bucket.Get([]byte(result.Filter)))
+			if result.Accepted {
+				stats.Accepted++
+			} else {
+				stats.Rejected++
+			}
+
+			if err := bucket.Put([]byte(result.Filter), encodeFilterStats(stats)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *backgroundDb) GetFilterStats() ([]FilterStats, error) {
+	var all []FilterStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		statsBucket := tx.Bucket([]byte(filterStatsBucket))
+		if statsBucket == nil {
+			return nil
+		}
+
+		return statsBucket.ForEach(func(sourceName []byte, _ []byte) error {
+			bucket := statsBucket.Bucket(sourceName)
+			if bucket == nil {
+				return nil
+			}
+
+			return bucket.ForEach(func(filterName []byte, val []byte) error {
+				stats := decodeFilterStats(val)
+				stats.Source = string(sourceName)
+				stats.Filter = string(filterName)
+				all = append(all, stats)
+				return nil
+			})
+		})
+	})
+	return all, err
+}
+
+func encodeFilterStats(stats FilterStats) []byte {
+	bytes := make([]byte, 16)
+	binary.BigEndian.PutUint64(bytes[:8], stats.Accepted)
+	binary.BigEndian.PutUint64(bytes[8:], stats.Rejected)
+	return bytes
+}
+
+func decodeFilterStats(bytes []byte) FilterStats {
+	if len(bytes) != 16 {
+		return FilterStats{}
+	}
+
+	return FilterStats{
+		Accepted: binary.BigEndian.Uint64(bytes[:8]),
+		Rejected: binary.BigEndian.Uint64(bytes[8:]),
+	}
+}
@@ -0,0 +1,92 @@
+package filters
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
+	"github.com/sirupsen/logrus"
+)
+
+type BusynessOptions struct {
+	RegionOptions `yaml:",inline"`
+
+	// EdgeThreshold is the gradient magnitude, as a fraction of the max, above which a pixel counts as an edge
+	EdgeThreshold float64 `yaml:"edgeThreshold"`
+
+	// MaxEdgeDensity is the max fraction of edge pixels allowed in any region
+	MaxEdgeDensity float64 `yaml:"maxEdgeDensity"`
+
+	// MaxEntropy is the max normalized luminance entropy allowed in any region
+	MaxEntropy float64 `yaml:"maxEntropy"`
+}
+
+func init() {
+	pipeline.AddFilterRegistration("busyness", NewBusynessFilter)
+}
+
+func NewBusynessFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	var options BusynessOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	if err := options.validateRegions(); err != nil {
+		return nil, err
+	}
+
+	// default vals if unset
+	if options.EdgeThreshold == 0 {
+		options.EdgeThreshold = 0.1
+	}
+	if options.MaxEdgeDensity == 0 {
+		options.MaxEdgeDensity = 1
+	}
+	if options.MaxEntropy == 0 {
+		options.MaxEntropy = 1
+	}
+
+	return &busynessFilter{
+		filterLog: filterLog,
+		opts:      &options,
+	}, nil
+}
+
+type busynessFilter struct {
+	filterLog *logrus.Entry
+	opts      *BusynessOptions
+}
+
+func (b *busynessFilter) IsValid(img background.Background) *filter.Rejection {
+	for i, stats := range b.opts.eachRegionStats(img) {
+		edgeDensity := stats.EdgeDensity(b.opts.EdgeThreshold)
+		entropy := stats.Entropy()
+		b.filterLog.Debugf("region %d edge density: %2f entropy: %2f", i, edgeDensity, entropy)
+
+		if edgeDensity > b.opts.MaxEdgeDensity {
+			return filter.Reject("region %d edge density %.3f > maxEdgeDensity %.3f", i, edgeDensity, b.opts.MaxEdgeDensity)
+		}
+		if entropy > b.opts.MaxEntropy {
+			return filter.Reject("region %d entropy %.3f > maxEntropy %.3f", i, entropy, b.opts.MaxEntropy)
+		}
+	}
+
+	return nil
+}
+
+// Score rates the background by how calm its regions are, relative to the configured max values
+func (b *busynessFilter) Score(img background.Background) float64 {
+	regions := b.opts.eachRegionStats(img)
+	if len(regions) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, stats := range regions {
+		edgeDensity := stats.EdgeDensity(b.opts.EdgeThreshold)
+		entropy := stats.Entropy()
+		total += (2 - edgeDensity/b.opts.MaxEdgeDensity - entropy/b.opts.MaxEntropy) / 2
+	}
+
+	return total / float64(len(regions))
+}
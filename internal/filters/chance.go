@@ -34,6 +34,21 @@ type chanceFilter struct {
 	chance    float64
 }
 
-func (c *chanceFilter) IsValid(img background.Background) bool {
-	return rand.Float64() < c.chance
+// Phase lets the pipeline roll the dice before the image is downloaded
+func (c *chanceFilter) Phase() filter.Phase {
+	return filter.PhaseMetadata
+}
+
+// IsVolatile keeps a bad roll from being remembered as a rejection
+func (c *chanceFilter) IsVolatile() bool {
+	return true
+}
+
+func (c *chanceFilter) IsValid(img background.Background) *filter.Rejection {
+	roll := rand.Float64()
+	if roll >= c.chance {
+		return filter.Reject("rolled %.3f >= chance %.3f", roll, c.chance)
+	}
+
+	return nil
 }
@@ -6,12 +6,12 @@
 	"bgfreshd/pkg/background"
 	"bgfreshd/pkg/filter"
 	"github.com/sirupsen/logrus"
-	"image"
 	"image/color"
 	"math"
 )
 
 type ColorOptions struct {
+	RegionOptions      `yaml:",inline"`
 	DesiredColor       *ColorRGBValue `yaml:"desiredColor"`
 	AcceptableDistance float64        `yaml:"acceptableDistance"`
 }
@@ -32,6 +32,10 @@ func NewColorFilter(config *filter.Configuration, filterLog *logrus.Entry) (filt
 		return nil, err
 	}
 
+	if err := options.validateRegions(); err != nil {
+		return nil, err
+	}
+
 	return &colorFilter{
 		filterLog: filterLog,
 		opt:       &options,
@@ -43,43 +47,33 @@ type colorFilter struct {
 	opt       *ColorOptions
 }
 
-func (c *colorFilter) IsValid(img background.Background) bool {
-	avg := computeAverageColor(img.GetImage())
+func (c *colorFilter) IsValid(img background.Background) *filter.Rejection {
+	avg := c.opt.regionStats(img).MeanColor()
 
 	c.filterLog.Debugf("avg r: %d g: %d b: %d", avg.R, avg.G, avg.B)
 	dist := computeDistance(c.opt.DesiredColor, avg)
 	c.filterLog.Debugf("distance: %2f", dist)
-	return dist < c.opt.AcceptableDistance
+	if dist >= c.opt.AcceptableDistance {
+		return filter.Reject("color distance %.3f >= acceptableDistance %.3f", dist, c.opt.AcceptableDistance)
+	}
+
+	return nil
 }
 
-// there exist methods to compute this better
-// take the simple route for now
-func computeAverageColor(img image.Image) color.NRGBA {
-	bounds := img.Bounds()
-	points := uint64(0)
-	red := uint64(0)
-	green := uint64(0)
-	blue := uint64(0)
-	for y := 0; y < bounds.Max.Y; y++ {
-		for x := 0; x < bounds.Max.X; x++ {
-			curColor := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
-			points++
-			red += uint64(curColor.R)
-			green += uint64(curColor.G)
-			blue += uint64(curColor.B)
+// Score rates the background by how close its average color is to the desired color
+func (c *colorFilter) Score(img background.Background) float64 {
+	avg := c.opt.regionStats(img).MeanColor()
+	dist := computeDistance(c.opt.DesiredColor, avg)
+
+	// no distance is acceptable, only the desired color itself is a fit
+	if c.opt.AcceptableDistance <= 0 {
+		if dist == 0 {
+			return 1
 		}
+		return 0
 	}
 
-	avgRed := red / points
-	avgGreen := green / points
-	avgBlue := blue / points
-
-	return color.NRGBA{
-		R: uint8(avgRed),
-		G: uint8(avgGreen),
-		B: uint8(avgBlue),
-		A: 255,
-	}
+	return 1 - dist/c.opt.AcceptableDistance
 }
 
 // simple euclidean distance for now
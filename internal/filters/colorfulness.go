@@ -0,0 +1,112 @@
+package filters
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"math"
+)
+
+type ColorfulnessOptions struct {
+	RegionOptions `yaml:",inline"`
+
+	// MinColorfulness and MaxColorfulness bound the Hasler-Süsstrunk metric
+	// roughly 0 is grayscale, 33 moderately colorful and 80+ extremely colorful
+	MinColorfulness float64 `yaml:"minColorfulness"`
+	MaxColorfulness float64 `yaml:"maxColorfulness"`
+
+	// MinSaturation and MaxSaturation bound the mean saturation in the range 0..1
+	MinSaturation float64 `yaml:"minSaturation"`
+	MaxSaturation float64 `yaml:"maxSaturation"`
+
+	// SaturationModel is either hsv or hsl, defaulting to hsv
+	SaturationModel string `yaml:"saturationModel"`
+
+	// GrayscaleOnly accepts only images whose colorfulness is at most GrayscaleThreshold
+	GrayscaleOnly      bool    `yaml:"grayscaleOnly"`
+	GrayscaleThreshold float64 `yaml:"grayscaleThreshold"`
+}
+
+func init() {
+	pipeline.AddFilterRegistration("colorfulness", NewColorfulnessFilter)
+}
+
+func NewColorfulnessFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	var options ColorfulnessOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	if err := options.validateRegions(); err != nil {
+		return nil, err
+	}
+
+	// default vals if unset
+	if options.MaxColorfulness == 0 {
+		options.MaxColorfulness = math.MaxFloat64
+	}
+	if options.MaxSaturation == 0 {
+		options.MaxSaturation = 1
+	}
+	if options.GrayscaleThreshold == 0 {
+		options.GrayscaleThreshold = 5
+	}
+
+	switch options.SaturationModel {
+	case "":
+		options.SaturationModel = "hsv"
+	case "hsv", "hsl":
+	default:
+		return nil, fmt.Errorf("unknown saturation model \"%s\", expected hsv or hsl", options.SaturationModel)
+	}
+
+	return &colorfulnessFilter{
+		filterLog: filterLog,
+		opts:      &options,
+	}, nil
+}
+
+type colorfulnessFilter struct {
+	filterLog *logrus.Entry
+	opts      *ColorfulnessOptions
+}
+
+func (c *colorfulnessFilter) IsValid(img background.Background) *filter.Rejection {
+	stats := c.opts.regionStats(img)
+	colorfulness := stats.Colorfulness()
+	saturation := stats.Saturation(c.opts.SaturationModel == "hsl")
+
+	img.AddMetadata("colorfulness", fmt.Sprintf("%.2f", colorfulness))
+	img.AddMetadata("saturation", fmt.Sprintf("%.3f", saturation))
+	c.filterLog.Debugf("colorfulness: %2f saturation: %2f", colorfulness, saturation)
+
+	switch {
+	case c.opts.GrayscaleOnly && colorfulness > c.opts.GrayscaleThreshold:
+		return filter.Reject("colorfulness %.2f > grayscaleThreshold %.2f", colorfulness, c.opts.GrayscaleThreshold)
+	case colorfulness < c.opts.MinColorfulness:
+		return filter.Reject("colorfulness %.2f < minColorfulness %.2f", colorfulness, c.opts.MinColorfulness)
+	case colorfulness > c.opts.MaxColorfulness:
+		return filter.Reject("colorfulness %.2f > maxColorfulness %.2f", colorfulness, c.opts.MaxColorfulness)
+	case saturation < c.opts.MinSaturation:
+		return filter.Reject("saturation %.3f < minSaturation %.3f", saturation, c.opts.MinSaturation)
+	case saturation > c.opts.MaxSaturation:
+		return filter.Reject("saturation %.3f > maxSaturation %.3f", saturation, c.opts.MaxSaturation)
+	}
+
+	return nil
+}
+
+// Score rates the background by how centered its colorfulness is within the configured bounds,
+// or by how close to grayscale it is in grayscale only mode
+func (c *colorfulnessFilter) Score(img background.Background) float64 {
+	colorfulness := c.opts.regionStats(img).Colorfulness()
+
+	if c.opts.GrayscaleOnly {
+		return 1 - colorfulness/c.opts.GrayscaleThreshold
+	}
+
+	return rangeScore(colorfulness, c.opts.MinColorfulness, c.opts.MaxColorfulness)
+}
@@ -0,0 +1,293 @@
+package filters
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exifAccept = "accept"
+	exifReject = "reject"
+
+	orientationLandscape = "landscape"
+	orientationPortrait  = "portrait"
+
+	earthRadiusKm = 6371.0
+)
+
+type ExifOptions struct {
+	// TakenAfter and TakenBefore bound the date taken, formatted as 2006-01-02
+	TakenAfter  string `yaml:"takenAfter"`
+	TakenBefore string `yaml:"takenBefore"`
+
+	// MaxAgeDays rejects photos taken longer ago than this
+	MaxAgeDays int `yaml:"maxAgeDays"`
+
+	// Cameras is an allow list matched case-insensitively against the camera make and model
+	Cameras []string `yaml:"cameras"`
+
+	// MinFocalLength and MaxFocalLength bound the focal length in mm
+	MinFocalLength float64 `yaml:"minFocalLength"`
+	MaxFocalLength float64 `yaml:"maxFocalLength"`
+
+	// Orientation is either landscape or portrait, accounting for EXIF rotation
+	Orientation string `yaml:"orientation"`
+
+	// GpsInclude requires the photo to be taken within one of the areas
+	GpsInclude []GpsArea `yaml:"gpsInclude"`
+
+	// GpsExclude rejects photos taken within any of the areas
+	GpsExclude []GpsArea `yaml:"gpsExclude"`
+
+	// MissingExif is the outcome, accept or reject, for images without the EXIF data a rule needs
+	MissingExif string `yaml:"missingExif"`
+}
+
+type GpsArea struct {
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+	RadiusKm  float64 `yaml:"radiusKm"`
+}
+
+func init() {
+	pipeline.AddFilterRegistration("exif", NewExifFilter)
+}
+
+func NewExifFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	var options ExifOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	newFilter := &exifFilter{
+		filterLog: filterLog,
+		opts:      &options,
+	}
+
+	var err error
+	if newFilter.takenAfter, err = parseExifDate(options.TakenAfter); err != nil {
+		return nil, err
+	}
+	if newFilter.takenBefore, err = parseExifDate(options.TakenBefore); err != nil {
+		return nil, err
+	}
+
+	switch options.MissingExif {
+	case "":
+		options.MissingExif = exifReject
+	case exifAccept, exifReject:
+	default:
+		return nil, fmt.Errorf("unknown missingExif outcome \"%s\", expected accept or reject", options.MissingExif)
+	}
+
+	switch options.Orientation {
+	case "", orientationLandscape, orientationPortrait:
+	default:
+		return nil, fmt.Errorf("unknown orientation \"%s\", expected landscape or portrait", options.Orientation)
+	}
+
+	// default max val if unset
+	if options.MaxFocalLength == 0 {
+		options.MaxFocalLength = math.MaxFloat64
+	}
+
+	return newFilter, nil
+}
+
+func parseExifDate(val string) (*time.Time, error) {
+	if len(val) == 0 {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse("2006-01-02", val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date \"%s\", expected the format 2006-01-02", val)
+	}
+
+	return &parsed, nil
+}
+
+type exifFilter struct {
+	filterLog   *logrus.Entry
+	opts        *ExifOptions
+	takenAfter  *time.Time
+	takenBefore *time.Time
+}
+
+func (e *exifFilter) IsValid(img background.Background) *filter.Rejection {
+	if !background.HasExif(img) {
+		return e.missing("image has no EXIF data")
+	}
+
+	if rejection := e.checkDate(img); rejection != nil {
+		return rejection
+	}
+	if rejection := e.checkCamera(img); rejection != nil {
+		return rejection
+	}
+	if rejection := e.checkFocalLength(img); rejection != nil {
+		return rejection
+	}
+	if rejection := e.checkOrientation(img); rejection != nil {
+		return rejection
+	}
+
+	return e.checkLocation(img)
+}
+
+// missing applies the configured outcome for absent EXIF data
+func (e *exifFilter) missing(reason string) *filter.Rejection {
+	if e.opts.MissingExif == exifAccept {
+		e.filterLog.Debugf("%s, accepting", reason)
+		return nil
+	}
+
+	return filter.Reject(reason)
+}
+
+func (e *exifFilter) checkDate(img background.Background) *filter.Rejection {
+	if e.takenAfter == nil && e.takenBefore == nil && e.opts.MaxAgeDays == 0 {
+		return nil
+	}
+
+	taken, err := time.Parse(time.RFC3339, img.GetMetadata(background.ExifDateTaken))
+	if err != nil {
+		return e.missing("image has no EXIF date taken")
+	}
+
+	if e.takenAfter != nil && taken.Before(*e.takenAfter) {
+		return filter.Reject("taken %s before takenAfter %s", taken.Format("2006-01-02"), e.opts.TakenAfter)
+	}
+	if e.takenBefore != nil && taken.After(*e.takenBefore) {
+		return filter.Reject("taken %s after takenBefore %s", taken.Format("2006-01-02"), e.opts.TakenBefore)
+	}
+
+	if e.opts.MaxAgeDays != 0 {
+		ageDays := int(time.Since(taken).Hours() / 24)
+		if ageDays > e.opts.MaxAgeDays {
+			return filter.Reject("taken %d days ago > maxAgeDays %d", ageDays, e.opts.MaxAgeDays)
+		}
+	}
+
+	return nil
+}
+
+func (e *exifFilter) checkCamera(img background.Background) *filter.Rejection {
+	if len(e.opts.Cameras) == 0 {
+		return nil
+	}
+
+	camera := strings.TrimSpace(fmt.Sprintf("%s %s", img.GetMetadata(background.ExifMake), img.GetMetadata(background.ExifModel)))
+	if len(camera) == 0 {
+		return e.missing("image has no EXIF camera")
+	}
+
+	lowered := strings.ToLower(camera)
+	for _, allowed := range e.opts.Cameras {
+		if strings.Contains(lowered, strings.ToLower(allowed)) {
+			return nil
+		}
+	}
+
+	return filter.Reject("camera \"%s\" not in allowed cameras", camera)
+}
+
+func (e *exifFilter) checkFocalLength(img background.Background) *filter.Rejection {
+	if e.opts.MinFocalLength == 0 && e.opts.MaxFocalLength == math.MaxFloat64 {
+		return nil
+	}
+
+	focalLength, err := strconv.ParseFloat(img.GetMetadata(background.ExifFocalLength), 64)
+	if err != nil {
+		return e.missing("image has no EXIF focal length")
+	}
+
+	if focalLength < e.opts.MinFocalLength {
+		return filter.Reject("focal length %.1fmm < minFocalLength %.1fmm", focalLength, e.opts.MinFocalLength)
+	}
+	if focalLength > e.opts.MaxFocalLength {
+		return filter.Reject("focal length %.1fmm > maxFocalLength %.1fmm", focalLength, e.opts.MaxFocalLength)
+	}
+
+	return nil
+}
+
+func (e *exifFilter) checkOrientation(img background.Background) *filter.Rejection {
+	if len(e.opts.Orientation) == 0 {
+		return nil
+	}
+
+	size, err := img.GetDimensions()
+	if err != nil {
+		return filter.RejectTransient("unable to determine dimensions: %s", err.Error())
+	}
+
+	// orientations 5 through 8 are displayed rotated by 90 degrees
+	width, height := size.X, size.Y
+	if orientation, err := strconv.Atoi(img.GetMetadata(background.ExifOrientation)); err == nil && orientation >= 5 {
+		width, height = height, width
+	}
+
+	orientation := orientationLandscape
+	if height > width {
+		orientation = orientationPortrait
+	}
+
+	if orientation != e.opts.Orientation {
+		return filter.Reject("orientation %s is not %s", orientation, e.opts.Orientation)
+	}
+
+	return nil
+}
+
+func (e *exifFilter) checkLocation(img background.Background) *filter.Rejection {
+	if len(e.opts.GpsInclude) == 0 && len(e.opts.GpsExclude) == 0 {
+		return nil
+	}
+
+	lat, latErr := strconv.ParseFloat(img.GetMetadata(background.ExifLatitude), 64)
+	long, longErr := strconv.ParseFloat(img.GetMetadata(background.ExifLongitude), 64)
+	if latErr != nil || longErr != nil {
+		return e.missing("image has no EXIF GPS location")
+	}
+
+	for _, area := range e.opts.GpsExclude {
+		if distance := haversineKm(lat, long, area.Latitude, area.Longitude); distance <= area.RadiusKm {
+			return filter.Reject("taken %.1fkm from excluded area %.4f,%.4f", distance, area.Latitude, area.Longitude)
+		}
+	}
+
+	if len(e.opts.GpsInclude) == 0 {
+		return nil
+	}
+
+	for _, area := range e.opts.GpsInclude {
+		if haversineKm(lat, long, area.Latitude, area.Longitude) <= area.RadiusKm {
+			return nil
+		}
+	}
+
+	return filter.Reject("taken at %.4f,%.4f outside of every included area", lat, long)
+}
+
+// haversineKm computes the great circle distance between two coordinates
+func haversineKm(lat1 float64, long1 float64, lat2 float64, long2 float64) float64 {
+	toRadians := func(deg float64) float64 {
+		return deg * math.Pi / 180
+	}
+
+	dLat := toRadians(lat2 - lat1)
+	dLong := toRadians(long2 - long1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLong/2)*math.Sin(dLong/2)
+
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
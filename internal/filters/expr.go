@@ -0,0 +1,242 @@
+package filters
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
+	"errors"
+	"fmt"
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/ast"
+	"github.com/antonmedv/expr/vm"
+	"github.com/sirupsen/logrus"
+	"image"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+type ExprOptions struct {
+	// Expression must evaluate to a bool, true meaning the background is valid
+	Expression string `yaml:"expression"`
+}
+
+// image statistics are expensive, so they're only computed when the expression references them
+const (
+	exprWidth        = "width"
+	exprHeight       = "height"
+	exprAspect       = "aspect"
+	exprLuminance    = "luminance"
+	exprColorfulness = "colorfulness"
+	exprSharpness    = "sharpness"
+	exprTemperature  = "temperature"
+	exprMeanColor    = "meanColor"
+)
+
+func init() {
+	pipeline.AddFilterRegistration("expr", NewExprFilter)
+}
+
+func NewExprFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	var options ExprOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	if len(options.Expression) == 0 {
+		return nil, errors.New("expr filter requires an expression")
+	}
+
+	identifiers := &identifierCollector{identifiers: map[string]bool{}}
+	program, err := expr.Compile(options.Expression, expr.Env(exprEnvironment(nil, image.Point{})), expr.AsBool(), expr.Patch(identifiers))
+	if err != nil {
+		return nil, fmt.Errorf("error compiling expression \"%s\": %s", options.Expression, err.Error())
+	}
+
+	// only pay for what the expression references
+	phase := filter.PhaseMetadata
+	for _, name := range []string{exprWidth, exprHeight, exprAspect} {
+		if identifiers.identifiers[name] {
+			phase = filter.PhaseDimensions
+		}
+	}
+	for _, name := range []string{exprLuminance, exprColorfulness, exprSharpness, exprTemperature, exprMeanColor} {
+		if identifiers.identifiers[name] {
+			phase = filter.PhasePixels
+		}
+	}
+
+	return &exprFilter{
+		filterLog:   filterLog,
+		expression:  options.Expression,
+		program:     program,
+		identifiers: identifiers.identifiers,
+		phase:       phase,
+	}, nil
+}
+
+type exprFilter struct {
+	filterLog   *logrus.Entry
+	expression  string
+	program     *vm.Program
+	identifiers map[string]bool
+	phase       filter.Phase
+}
+
+func (e *exprFilter) Phase() filter.Phase {
+	return e.phase
+}
+
+func (e *exprFilter) IsValid(img background.Background) *filter.Rejection {
+	size := image.Point{}
+	if e.phase >= filter.PhaseDimensions {
+		var err error
+		if size, err = img.GetDimensions(); err != nil {
+			return filter.RejectTransient("unable to determine dimensions: %s", err.Error())
+		}
+	}
+
+	env := exprEnvironment(img, size)
+	if e.phase < filter.PhasePixels {
+		return e.run(env)
+	}
+
+	whole := img.GetAnalysis().Whole()
+	if e.identifiers[exprLuminance] {
+		env[exprLuminance] = whole.Luminance()
+	}
+	if e.identifiers[exprColorfulness] {
+		env[exprColorfulness] = whole.Colorfulness()
+	}
+	if e.identifiers[exprSharpness] {
+		env[exprSharpness] = whole.Sharpness()
+	}
+	if e.identifiers[exprTemperature] {
+		env[exprTemperature] = background.CCT(whole.Chromaticity())
+	}
+	if e.identifiers[exprMeanColor] {
+		avg := whole.MeanColor()
+		env[exprMeanColor] = map[string]int{
+			"r": int(avg.R),
+			"g": int(avg.G),
+			"b": int(avg.B),
+		}
+	}
+
+	return e.run(env)
+}
+
+func (e *exprFilter) run(env map[string]interface{}) *filter.Rejection {
+	result, err := expr.Run(e.program, env)
+	if err != nil {
+		e.filterLog.Warnf("error evaluating expression: %s", err.Error())
+		return filter.RejectTransient("error evaluating expression: %s", err.Error())
+	}
+
+	e.filterLog.Debugf("expression result: %v", result)
+	if !result.(bool) {
+		return filter.Reject("expression \"%s\" is false", e.expression)
+	}
+
+	return nil
+}
+
+// exprEnvironment builds the variables and helpers visible to expressions
+// a nil background produces the zero valued environment used for type checking
+func exprEnvironment(img background.Background, size image.Point) map[string]interface{} {
+	meta := map[string]string{}
+	if img != nil {
+		for _, key := range img.GetMetadataKeys() {
+			meta[key] = img.GetMetadata(key)
+		}
+	}
+
+	aspect := 0.0
+	if size.Y != 0 {
+		aspect = float64(size.X) / float64(size.Y)
+	}
+
+	return map[string]interface{}{
+		"meta":      meta,
+		"title":     meta["title"],
+		"permalink": meta["permalink"],
+		"source":    meta["source-name"],
+		exprWidth:   size.X,
+		exprHeight:  size.Y,
+		exprAspect:  aspect,
+
+		exprLuminance:    0.0,
+		exprColorfulness: 0.0,
+		exprSharpness:    0.0,
+		exprTemperature:  0.0,
+		exprMeanColor:    map[string]int{"r": 0, "g": 0, "b": 0},
+
+		"matchesAny":  exprMatchesAny,
+		"containsAny": exprContainsAny,
+		"oneOf":       exprOneOf,
+	}
+}
+
+// exprMatchesAny reports whether val matches any of the given regular expressions
+func exprMatchesAny(val string, patterns interface{}) bool {
+	for _, pattern := range exprStrings(patterns) {
+		if matched, err := regexp.MatchString(pattern, val); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exprContainsAny reports whether val contains any of the given words, ignoring case
+func exprContainsAny(val string, words interface{}) bool {
+	lowered := strings.ToLower(val)
+	for _, word := range exprStrings(words) {
+		if strings.Contains(lowered, strings.ToLower(word)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exprOneOf reports whether val equals any item of list, ignoring case
+func exprOneOf(val string, list interface{}) bool {
+	for _, item := range exprStrings(list) {
+		if strings.EqualFold(val, item) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exprStrings converts any list produced by an expression into strings
+// constant lists may be folded into typed slices by the optimizer, so reflection is required
+func exprStrings(list interface{}) []string {
+	value := reflect.ValueOf(list)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return []string{fmt.Sprint(list)}
+	}
+
+	strs := make([]string, value.Len())
+	for i := range strs {
+		strs[i] = fmt.Sprint(value.Index(i).Interface())
+	}
+
+	return strs
+}
+
+// identifierCollector records every identifier referenced by an expression
+type identifierCollector struct {
+	identifiers map[string]bool
+}
+
+func (i *identifierCollector) Enter(_ *ast.Node) {}
+
+func (i *identifierCollector) Exit(node *ast.Node) {
+	if n, ok := (*node).(*ast.IdentifierNode); ok {
+		i.identifiers[n.Value] = true
+	}
+}
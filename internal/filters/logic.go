@@ -0,0 +1,325 @@
+package filters
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"math"
+	"strings"
+)
+
+// GroupOptions holds the nested filters of a boolean composition filter
+type GroupOptions struct {
+	Filters []filter.Configuration `yaml:"filters"`
+}
+
+func init() {
+	pipeline.AddFilterRegistration("all", NewAllFilter)
+	pipeline.AddFilterRegistration("any", NewAnyFilter)
+	pipeline.AddFilterRegistration("not", NewNotFilter)
+}
+
+func NewAllFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	branches, err := loadBranches(config, filterLog)
+	if err != nil {
+		return nil, err
+	}
+
+	return withScore(&allFilter{
+		groupFilter: groupFilter{
+			filterLog: filterLog,
+			branches:  branches,
+		},
+	}), nil
+}
+
+func NewAnyFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	branches, err := loadBranches(config, filterLog)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(branches) == 0 {
+		return nil, errors.New("any filter requires at least one nested filter")
+	}
+
+	return withScore(&anyFilter{
+		groupFilter: groupFilter{
+			filterLog: filterLog,
+			branches:  branches,
+		},
+	}), nil
+}
+
+func NewNotFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	branches, err := loadBranches(config, filterLog)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(branches) == 0 {
+		return nil, errors.New("not filter requires at least one nested filter")
+	}
+
+	return withScore(&notFilter{
+		groupFilter: groupFilter{
+			filterLog: filterLog,
+			branches:  branches,
+		},
+	}), nil
+}
+
+// branch is a single nested filter of a group, named by its position in the group
+// nested groups extend the name of their parent, IE all[1].any[0]
+type branch struct {
+	name   string
+	filter filter.Filter
+	weight float64
+}
+
+func loadBranches(config *filter.Configuration, filterLog *logrus.Entry) ([]branch, error) {
+	var options GroupOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	prefix := ""
+	if parent, ok := filterLog.Data["branch"]; ok {
+		prefix = fmt.Sprintf("%s.", parent)
+	}
+
+	branches := make([]branch, 0, len(options.Filters))
+	for i := range options.Filters {
+		child := &options.Filters[i]
+		name := fmt.Sprintf("%s%s[%d]", prefix, config.Type, i)
+		childFilter, err := pipeline.CreateFilter(child, filterLog.WithFields(logrus.Fields{
+			"branch": name,
+		}))
+		if err != nil {
+			return nil, err
+		}
+
+		weight := 1.0
+		if child.Weight != nil {
+			weight = *child.Weight
+		}
+
+		branches = append(branches, branch{
+			name:   fmt.Sprintf("%s (%s)", name, child.Type),
+			filter: childFilter,
+			weight: weight,
+		})
+	}
+
+	return branches, nil
+}
+
+// groupFilter holds the nested filters shared by every composition filter
+type groupFilter struct {
+	filterLog *logrus.Entry
+	branches  []branch
+}
+
+// Phase is the most expensive phase of any nested filter
+func (g *groupFilter) Phase() filter.Phase {
+	phase := filter.PhaseMetadata
+	for _, b := range g.branches {
+		if branchPhase := filter.PhaseOf(b.filter); branchPhase > phase {
+			phase = branchPhase
+		}
+	}
+
+	return phase
+}
+
+// IsVolatile is set when any nested filter is volatile
+func (g *groupFilter) IsVolatile() bool {
+	for _, b := range g.branches {
+		if filter.IsVolatile(b.filter) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fingerprint combines the fingerprints of the nested filters
+func (g *groupFilter) Fingerprint() string {
+	fingerprints := make([]string, 0, len(g.branches))
+	for _, b := range g.branches {
+		if fingerprinter, ok := b.filter.(filter.Fingerprinter); ok {
+			fingerprints = append(fingerprints, fmt.Sprintf("%s=%s", b.name, fingerprinter.Fingerprint()))
+		}
+	}
+
+	return strings.Join(fingerprints, ";")
+}
+
+// group is implemented by every composition filter, letting a scoring group be wrapped without losing the rest
+type group interface {
+	filter.Filter
+	Phase() filter.Phase
+	IsVolatile() bool
+	Fingerprint() string
+	scorers() []branch
+
+	// combineScores merges the scores of the scoring branches, weights are the branches' own
+	combineScores(scores []float64, weights []float64) float64
+}
+
+// scoringGroup forwards the scores of a group's scoring branches
+type scoringGroup struct {
+	group
+}
+
+// withScore lets the group score when any of its branches do. groups without a scoring branch are left
+// as they are, so they don't count towards the chain's score
+func withScore(g group) filter.Filter {
+	if len(g.scorers()) == 0 {
+		return g
+	}
+
+	return &scoringGroup{group: g}
+}
+
+func (s *scoringGroup) Score(img background.Background) float64 {
+	scorers := s.scorers()
+	scores := make([]float64, 0, len(scorers))
+	weights := make([]float64, 0, len(scorers))
+	for _, b := range scorers {
+		scores = append(scores, math.Max(0, math.Min(1, b.filter.(filter.Scorer).Score(img))))
+		weights = append(weights, b.weight)
+	}
+
+	return s.combineScores(scores, weights)
+}
+
+// scorers returns the branches that score, nested groups included when their own branches do
+func (g *groupFilter) scorers() []branch {
+	var scorers []branch
+	for _, b := range g.branches {
+		if _, ok := b.filter.(filter.Scorer); ok {
+			scorers = append(scorers, b)
+		}
+	}
+
+	return scorers
+}
+
+func weightedMean(scores []float64, weights []float64) float64 {
+	total, totalWeight := 0.0, 0.0
+	for i, score := range scores {
+		total += score * weights[i]
+		totalWeight += weights[i]
+	}
+
+	if totalWeight == 0 {
+		return 0
+	}
+
+	return total / totalWeight
+}
+
+type allFilter struct {
+	groupFilter
+}
+
+// combineScores is the weighted mean of the branches, every one of them has to fit
+func (a *allFilter) combineScores(scores []float64, weights []float64) float64 {
+	return weightedMean(scores, weights)
+}
+
+func (a *allFilter) IsValid(img background.Background) *filter.Rejection {
+	for _, b := range a.branches {
+		if rejection := b.filter.IsValid(img); rejection != nil {
+			a.filterLog.Debugf("branch %s rejected: %s, verdict: reject", b.name, rejection)
+			return &filter.Rejection{
+				Reason:    fmt.Sprintf("branch %s: %s", b.name, rejection),
+				Transient: rejection.Transient,
+			}
+		}
+	}
+
+	a.filterLog.Debug("all branches approved, verdict: approve")
+	return nil
+}
+
+type anyFilter struct {
+	groupFilter
+}
+
+// combineScores is the best of the branches, one fitting is enough
+func (a *anyFilter) combineScores(scores []float64, _ []float64) float64 {
+	best := 0.0
+	for _, score := range scores {
+		best = math.Max(best, score)
+	}
+
+	return best
+}
+
+func (a *anyFilter) IsValid(img background.Background) *filter.Rejection {
+	reasons := make([]string, 0, len(a.branches))
+	transient := false
+	for _, b := range a.branches {
+		rejection := b.filter.IsValid(img)
+		if rejection == nil {
+			a.filterLog.Debugf("branch %s approved, verdict: approve", b.name)
+			return nil
+		}
+
+		reasons = append(reasons, fmt.Sprintf("%s: %s", b.name, rejection))
+		transient = transient || rejection.Transient
+	}
+
+	a.filterLog.Debug("no branches approved, verdict: reject")
+	return &filter.Rejection{
+		Reason:    fmt.Sprintf("no branch approved (%s)", strings.Join(reasons, "; ")),
+		Transient: transient,
+	}
+}
+
+type notFilter struct {
+	groupFilter
+}
+
+// combineScores inverts the weighted mean of the branches, fitting them poorly is what's wanted
+func (n *notFilter) combineScores(scores []float64, weights []float64) float64 {
+	return 1 - weightedMean(scores, weights)
+}
+
+func (n *notFilter) IsValid(img background.Background) *filter.Rejection {
+	// a branch that couldn't be judged, IE a failed download, isn't a rejection to negate
+	var transient *filter.Rejection
+	var transientBranch string
+	for _, b := range n.branches {
+		rejection := b.filter.IsValid(img)
+		switch {
+		case rejection == nil:
+			continue
+		case rejection.Transient:
+			if transient == nil {
+				transient, transientBranch = rejection, b.name
+			}
+			continue
+		}
+
+		n.filterLog.Debugf("branch %s rejected: %s, verdict: approve", b.name, rejection)
+		return nil
+	}
+
+	if transient != nil {
+		n.filterLog.Debugf("branch %s couldn't be judged: %s, verdict: reject", transientBranch, transient)
+		return &filter.Rejection{
+			Reason:    fmt.Sprintf("branch %s: %s", transientBranch, transient),
+			Transient: true,
+		}
+	}
+
+	n.filterLog.Debug("all branches approved, verdict: reject")
+	return filter.Reject("all negated branches approved")
+}
@@ -0,0 +1,66 @@
+package filters
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
+	"github.com/sirupsen/logrus"
+)
+
+type LuminanceOptions struct {
+	RegionOptions `yaml:",inline"`
+	MinLuminance  float64 `yaml:"minLuminance"`
+	MaxLuminance  float64 `yaml:"maxLuminance"`
+}
+
+func init() {
+	pipeline.AddFilterRegistration("luminance", NewLuminanceFilter)
+}
+
+func NewLuminanceFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	var options LuminanceOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	if err := options.validateRegions(); err != nil {
+		return nil, err
+	}
+
+	// default max val if unset
+	if options.MaxLuminance == 0 {
+		options.MaxLuminance = 1
+	}
+
+	return &luminanceFilter{
+		filterLog: filterLog,
+		opts:      &options,
+	}, nil
+}
+
+type luminanceFilter struct {
+	filterLog *logrus.Entry
+	opts      *LuminanceOptions
+}
+
+func (l *luminanceFilter) IsValid(img background.Background) *filter.Rejection {
+	lum := l.opts.regionStats(img).Luminance()
+	l.filterLog.Debugf("luminance: %2f", lum)
+
+	if lum < l.opts.MinLuminance {
+		return filter.Reject("luminance %.3f < minLuminance %.3f", lum, l.opts.MinLuminance)
+	}
+	if lum > l.opts.MaxLuminance {
+		return filter.Reject("luminance %.3f > maxLuminance %.3f", lum, l.opts.MaxLuminance)
+	}
+
+	return nil
+}
+
+// Score rates the background by how centered its luminance is within the configured bounds
+func (l *luminanceFilter) Score(img background.Background) float64 {
+	lum := l.opts.regionStats(img).Luminance()
+
+	return rangeScore(lum, l.opts.MinLuminance, l.opts.MaxLuminance)
+}
@@ -0,0 +1,253 @@
+package filters
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type MetadataOptions struct {
+	// Required keys must be present and non empty
+	Required []string `yaml:"required"`
+
+	// Forbidden keys must be absent or empty
+	Forbidden []string `yaml:"forbidden"`
+
+	// Rules are applied to the value of the metadata key they're mapped to
+	Rules map[string]MetadataRule `yaml:"rules"`
+}
+
+type MetadataRule struct {
+	// Include regular expressions, at least one must match when set
+	Include []string `yaml:"include"`
+
+	// Exclude regular expressions, none may match
+	Exclude []string `yaml:"exclude"`
+
+	// IncludeWords are case-insensitive words or phrases, at least one must be present when set
+	IncludeWords []string `yaml:"includeWords"`
+
+	// ExcludeWords are case-insensitive words or phrases, none may be present
+	ExcludeWords []string `yaml:"excludeWords"`
+
+	// IncludeWordFiles are files of additional include words, one per line
+	IncludeWordFiles []string `yaml:"includeWordFiles"`
+
+	// ExcludeWordFiles are files of additional exclude words, one per line
+	ExcludeWordFiles []string `yaml:"excludeWordFiles"`
+}
+
+func init() {
+	pipeline.AddFilterRegistration("metadata", NewMetadataFilter)
+}
+
+func NewMetadataFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	var options MetadataOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	rules := make([]*metadataRule, 0, len(options.Rules))
+	for key, rule := range options.Rules {
+		compiled, err := compileMetadataRule(key, &rule, filterLog)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, compiled)
+	}
+
+	return &metadataFilter{
+		filterLog: filterLog,
+		opts:      &options,
+		rules:     rules,
+	}, nil
+}
+
+type metadataFilter struct {
+	filterLog *logrus.Entry
+	opts      *MetadataOptions
+	rules     []*metadataRule
+}
+
+// Phase lets the pipeline run this filter before the image is downloaded
+func (m *metadataFilter) Phase() filter.Phase {
+	return filter.PhaseMetadata
+}
+
+// Fingerprint covers the word lists, which may have been read from files
+func (m *metadataFilter) Fingerprint() string {
+	lists := make([]string, 0, len(m.rules))
+	for _, rule := range m.rules {
+		lists = append(lists, fmt.Sprintf("%s:%v:%v", rule.key, rule.includeWords, rule.excludeWords))
+	}
+
+	sort.Strings(lists)
+	return strings.Join(lists, ";")
+}
+
+func (m *metadataFilter) IsValid(img background.Background) *filter.Rejection {
+	for _, key := range m.opts.Required {
+		if len(img.GetMetadata(key)) == 0 {
+			return filter.Reject("required key %s missing", key)
+		}
+	}
+
+	for _, key := range m.opts.Forbidden {
+		if len(img.GetMetadata(key)) != 0 {
+			return filter.Reject("forbidden key %s present", key)
+		}
+	}
+
+	for _, rule := range m.rules {
+		if rejection := rule.check(img.GetMetadata(rule.key)); rejection != nil {
+			return rejection
+		}
+	}
+
+	return nil
+}
+
+type metadataRule struct {
+	key          string
+	include      []*regexp.Regexp
+	exclude      []*regexp.Regexp
+	includeWords *regexp.Regexp
+	excludeWords *regexp.Regexp
+}
+
+func compileMetadataRule(key string, rule *MetadataRule, filterLog *logrus.Entry) (*metadataRule, error) {
+	include, err := compilePatterns(rule.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := compilePatterns(rule.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	includeWords, err := compileWordList(rule.IncludeWords, rule.IncludeWordFiles, filterLog)
+	if err != nil {
+		return nil, err
+	}
+
+	excludeWords, err := compileWordList(rule.ExcludeWords, rule.ExcludeWordFiles, filterLog)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metadataRule{
+		key:          key,
+		include:      include,
+		exclude:      exclude,
+		includeWords: includeWords,
+		excludeWords: excludeWords,
+	}, nil
+}
+
+func (r *metadataRule) check(val string) *filter.Rejection {
+	if len(r.include) != 0 {
+		found := false
+		for _, pattern := range r.include {
+			if pattern.MatchString(val) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return filter.Reject("%s \"%s\" matches no include pattern", r.key, val)
+		}
+	}
+
+	for _, pattern := range r.exclude {
+		if pattern.MatchString(val) {
+			return filter.Reject("%s \"%s\" matches exclude pattern %s", r.key, val, pattern)
+		}
+	}
+
+	if r.includeWords != nil && !r.includeWords.MatchString(val) {
+		return filter.Reject("%s \"%s\" contains no include words", r.key, val)
+	}
+
+	if r.excludeWords != nil && r.excludeWords.MatchString(val) {
+		word := strings.TrimSpace(r.excludeWords.FindString(val))
+		return filter.Reject("%s \"%s\" contains excluded word \"%s\"", r.key, val, word)
+	}
+
+	return nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern \"%s\": %s", pattern, err.Error())
+		}
+
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
+
+// compileWordList builds a single case-insensitive whole word matcher from
+// the given words and the contents of the given word files
+func compileWordList(words []string, files []string, filterLog *logrus.Entry) (*regexp.Regexp, error) {
+	all := append([]string{}, words...)
+	for _, file := range files {
+		loaded, err := loadWordFile(file, filterLog)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, loaded...)
+	}
+
+	quoted := make([]string, 0, len(all))
+	for _, word := range all {
+		word = strings.TrimSpace(word)
+		if len(word) != 0 {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+
+	if len(quoted) == 0 {
+		return nil, nil
+	}
+
+	// \b doesn't treat punctuation such as "[OC]" as part of a word, so the boundaries are spelled out
+	return regexp.Compile(fmt.Sprintf(`(?i)(?:^|[^\pL\pN])(?:%s)(?:[^\pL\pN]|$)`, strings.Join(quoted, "|")))
+}
+
+// loadWordFile reads one word or phrase per line, ignoring blank lines and lines starting with #
+func loadWordFile(path string, filterLog *logrus.Entry) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.Deferrer(filterLog, file.Close)
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		words = append(words, line)
+	}
+
+	return words, scanner.Err()
+}
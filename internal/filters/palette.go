@@ -0,0 +1,191 @@
+package filters
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/goccy/go-yaml"
+	"image/color"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// palette formats understood by the theme filter
+const (
+	paletteFormatPywal      = "pywal"
+	paletteFormatBase16     = "base16"
+	paletteFormatXresources = "xresources"
+)
+
+// palette is a theme's colors split by the role they play
+type palette struct {
+	backgrounds []color.NRGBA
+	accents     []color.NRGBA
+}
+
+// detectPaletteFormat guesses the format of a palette file by its name
+func detectPaletteFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return paletteFormatPywal, nil
+	case ".yaml", ".yml":
+		return paletteFormatBase16, nil
+	}
+
+	if strings.Contains(strings.ToLower(filepath.Base(path)), "xresources") {
+		return paletteFormatXresources, nil
+	}
+
+	return "", fmt.Errorf("unable to detect the palette format of %s, set format to pywal, base16 or xresources", path)
+}
+
+func parsePalette(format string, contents []byte) (*palette, error) {
+	switch format {
+	case paletteFormatPywal:
+		return parsePywal(contents)
+	case paletteFormatBase16:
+		return parseBase16(contents)
+	case paletteFormatXresources:
+		return parseXresources(contents)
+	}
+
+	return nil, fmt.Errorf("unknown palette format \"%s\", expected pywal, base16 or xresources", format)
+}
+
+// parsePywal reads a pywal colors.json, where color0 doubles as the background
+// and colors 1 through 6 and 9 through 14 are the accents
+func parsePywal(contents []byte) (*palette, error) {
+	var scheme struct {
+		Special map[string]string `json:"special"`
+		Colors  map[string]string `json:"colors"`
+	}
+	if err := json.Unmarshal(contents, &scheme); err != nil {
+		return nil, err
+	}
+
+	p := &palette{}
+	for _, hex := range []string{scheme.Special["background"], scheme.Colors["color0"]} {
+		if c, err := parseHexColor(hex); err == nil {
+			p.backgrounds = append(p.backgrounds, c)
+		}
+	}
+
+	for _, i := range []int{1, 2, 3, 4, 5, 6, 9, 10, 11, 12, 13, 14} {
+		if c, err := parseHexColor(scheme.Colors[fmt.Sprintf("color%d", i)]); err == nil {
+			p.accents = append(p.accents, c)
+		}
+	}
+
+	return p.validate()
+}
+
+// parseBase16 reads a base16 scheme, where base00 and base01 are backgrounds
+// and base08 through base0F are the accents
+func parseBase16(contents []byte) (*palette, error) {
+	var scheme map[string]interface{}
+	if err := yaml.Unmarshal(contents, &scheme); err != nil {
+		return nil, err
+	}
+
+	lookup := func(key string) (color.NRGBA, error) {
+		val, ok := scheme[key]
+		if !ok {
+			return color.NRGBA{}, fmt.Errorf("missing %s", key)
+		}
+
+		// unquoted all digit values such as 181818 are decoded as numbers
+		if number, ok := val.(uint64); ok {
+			return parseHexColor(fmt.Sprintf("%06d", number))
+		}
+
+		return parseHexColor(fmt.Sprint(val))
+	}
+
+	p := &palette{}
+	for _, key := range []string{"base00", "base01"} {
+		if c, err := lookup(key); err == nil {
+			p.backgrounds = append(p.backgrounds, c)
+		}
+	}
+
+	for _, key := range []string{"base08", "base09", "base0A", "base0B", "base0C", "base0D", "base0E", "base0F"} {
+		if c, err := lookup(key); err == nil {
+			p.accents = append(p.accents, c)
+		}
+	}
+
+	return p.validate()
+}
+
+var xresourcesLine = regexp.MustCompile(`^[\w.*-]*[.*](background|color(\d+))\s*:\s*(#?[0-9a-fA-F]{3,6})\s*$`)
+
+// parseXresources reads the background and color resources of an Xresources file,
+// using the same roles as pywal
+func parseXresources(contents []byte) (*palette, error) {
+	p := &palette{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		match := xresourcesLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+
+		c, err := parseHexColor(match[3])
+		if err != nil {
+			continue
+		}
+
+		if match[1] == "background" {
+			p.backgrounds = append(p.backgrounds, c)
+			continue
+		}
+
+		switch index, _ := strconv.Atoi(match[2]); index {
+		case 0, 8:
+			p.backgrounds = append(p.backgrounds, c)
+		case 1, 2, 3, 4, 5, 6, 9, 10, 11, 12, 13, 14:
+			p.accents = append(p.accents, c)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return p.validate()
+}
+
+func (p *palette) validate() (*palette, error) {
+	if len(p.backgrounds) == 0 && len(p.accents) == 0 {
+		return nil, fmt.Errorf("palette contains no colors")
+	}
+
+	return p, nil
+}
+
+// parseHexColor parses #rrggbb or #rgb, with or without the leading #
+func parseHexColor(hex string) (color.NRGBA, error) {
+	hex = strings.TrimPrefix(strings.TrimSpace(hex), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	if len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid hex color \"%s\"", hex)
+	}
+
+	val, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid hex color \"%s\"", hex)
+	}
+
+	return color.NRGBA{
+		R: uint8(val >> 16),
+		G: uint8(val >> 8),
+		B: uint8(val),
+		A: 255,
+	}, nil
+}
@@ -0,0 +1,85 @@
+package filters
+
+import (
+	"bgfreshd/pkg/background"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Region is a sub-rectangle of an image expressed as fractions of the image's
+// width and height, so that it applies regardless of the image's resolution
+type Region struct {
+	X      float64 `yaml:"x"`
+	Y      float64 `yaml:"y"`
+	Width  float64 `yaml:"width"`
+	Height float64 `yaml:"height"`
+}
+
+// RegionOptions can be embedded in the options of any pixel based filter to
+// restrict it to one or more regions of the image
+type RegionOptions struct {
+	Regions []Region `yaml:"regions"`
+}
+
+type InvalidRegionError struct {
+	Region Region
+}
+
+func (i InvalidRegionError) Error() string {
+	return fmt.Sprintf("invalid region x: %f y: %f width: %f height: %f, values must be fractions between 0 and 1", i.Region.X, i.Region.Y, i.Region.Width, i.Region.Height)
+}
+
+func (r *RegionOptions) validateRegions() error {
+	for _, region := range r.Regions {
+		if region.X < 0 || region.Y < 0 || region.Width <= 0 || region.Height <= 0 ||
+			region.X+region.Width > 1 || region.Y+region.Height > 1 {
+			return &InvalidRegionError{Region: region}
+		}
+	}
+
+	return nil
+}
+
+// regionStats returns the statistics of the union of the configured regions
+func (r *RegionOptions) regionStats(img background.Background) *background.RegionStats {
+	analysis := img.GetAnalysis()
+	return analysis.Regions(r.resolveRegions(analysis.Bounds())...)
+}
+
+// eachRegionStats returns the statistics of each configured region individually
+func (r *RegionOptions) eachRegionStats(img background.Background) []*background.RegionStats {
+	analysis := img.GetAnalysis()
+	rects := r.resolveRegions(analysis.Bounds())
+	stats := make([]*background.RegionStats, 0, len(rects))
+	for _, rect := range rects {
+		stats = append(stats, analysis.Regions(rect))
+	}
+
+	return stats
+}
+
+// resolveRegions maps the configured regions onto the given bounds
+// when no regions are configured, the whole image is used
+func (r *RegionOptions) resolveRegions(bounds image.Rectangle) []image.Rectangle {
+	if len(r.Regions) == 0 {
+		return []image.Rectangle{bounds}
+	}
+
+	width := float64(bounds.Dx())
+	height := float64(bounds.Dy())
+	rects := make([]image.Rectangle, 0, len(r.Regions))
+	for _, region := range r.Regions {
+		minX := bounds.Min.X + int(math.Floor(region.X*width))
+		minY := bounds.Min.Y + int(math.Floor(region.Y*height))
+		maxX := bounds.Min.X + int(math.Ceil((region.X+region.Width)*width))
+		maxY := bounds.Min.Y + int(math.Ceil((region.Y+region.Height)*height))
+
+		rect := image.Rect(minX, minY, maxX, maxY).Intersect(bounds)
+		if !rect.Empty() {
+			rects = append(rects, rect)
+		}
+	}
+
+	return rects
+}
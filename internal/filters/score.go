@@ -0,0 +1,22 @@
+package filters
+
+import "math"
+
+// rangeScore rates how centered val is within min..max, 1 at the center and 0 at or outside the bounds
+// an unbounded max rates every val at or above min as 1
+func rangeScore(val float64, min float64, max float64) float64 {
+	if val < min || val > max {
+		return 0
+	}
+
+	if max == math.MaxFloat64 {
+		return 1
+	}
+
+	halfRange := (max - min) / 2
+	if halfRange == 0 {
+		return 1
+	}
+
+	return 1 - math.Abs(val-(min+halfRange))/halfRange
+}
@@ -45,10 +45,28 @@ type sizeFilter struct {
 	opts      *SizeOptions
 }
 
-func (c *sizeFilter) IsValid(img background.Background) bool {
-	i := img.GetImage()
-	bounds := i.Bounds()
-	c.filterLog.Debugf("image size x: %d y: %d", bounds.Max.X, bounds.Max.Y)
+// Phase lets the pipeline check the size before the image is decoded
+func (c *sizeFilter) Phase() filter.Phase {
+	return filter.PhaseDimensions
+}
+
+func (c *sizeFilter) IsValid(img background.Background) *filter.Rejection {
+	size, err := img.GetDimensions()
+	if err != nil {
+		return filter.RejectTransient("unable to determine dimensions: %s", err.Error())
+	}
+	c.filterLog.Debugf("image size x: %d y: %d", size.X, size.Y)
+
+	switch {
+	case size.X < c.opts.MinX:
+		return filter.Reject("width %d < minX %d", size.X, c.opts.MinX)
+	case size.Y < c.opts.MinY:
+		return filter.Reject("height %d < minY %d", size.Y, c.opts.MinY)
+	case size.X > c.opts.MaxX:
+		return filter.Reject("width %d > maxX %d", size.X, c.opts.MaxX)
+	case size.Y > c.opts.MaxY:
+		return filter.Reject("height %d > maxY %d", size.Y, c.opts.MaxY)
+	}
 
-	return bounds.Max.X >= c.opts.MinX && bounds.Max.Y >= c.opts.MinY && bounds.Max.X <= c.opts.MaxX && bounds.Max.Y <= c.opts.MaxY
+	return nil
 }
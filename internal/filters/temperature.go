@@ -0,0 +1,80 @@
+package filters
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"math"
+)
+
+type TemperatureOptions struct {
+	RegionOptions `yaml:",inline"`
+
+	// MinKelvin and MaxKelvin bound the correlated color temperature
+	// roughly 2000 is candle light, 3000 a sunset, 5500 daylight and 8000+ shade or blue sky
+	MinKelvin float64 `yaml:"minKelvin"`
+	MaxKelvin float64 `yaml:"maxKelvin"`
+}
+
+func init() {
+	pipeline.AddFilterRegistration("temperature", NewTemperatureFilter)
+}
+
+func NewTemperatureFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	var options TemperatureOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	if err := options.validateRegions(); err != nil {
+		return nil, err
+	}
+
+	// default max val if unset
+	if options.MaxKelvin == 0 {
+		options.MaxKelvin = math.MaxFloat64
+	}
+
+	return &temperatureFilter{
+		filterLog: filterLog,
+		opts:      &options,
+	}, nil
+}
+
+type temperatureFilter struct {
+	filterLog *logrus.Entry
+	opts      *TemperatureOptions
+}
+
+func (t *temperatureFilter) IsValid(img background.Background) *filter.Rejection {
+	kelvin, tint := t.temperature(img)
+
+	img.AddMetadata("temperature", fmt.Sprintf("%.0f", kelvin))
+	img.AddMetadata("tint", fmt.Sprintf("%.4f", tint))
+	t.filterLog.Debugf("temperature: %.0fK tint: %.4f", kelvin, tint)
+
+	if kelvin < t.opts.MinKelvin {
+		return filter.Reject("temperature %.0fK < minKelvin %.0fK", kelvin, t.opts.MinKelvin)
+	}
+	if kelvin > t.opts.MaxKelvin {
+		return filter.Reject("temperature %.0fK > maxKelvin %.0fK", kelvin, t.opts.MaxKelvin)
+	}
+
+	return nil
+}
+
+// Score rates the background by how centered its temperature is within the configured bounds
+func (t *temperatureFilter) Score(img background.Background) float64 {
+	kelvin, _ := t.temperature(img)
+	return rangeScore(kelvin, t.opts.MinKelvin, t.opts.MaxKelvin)
+}
+
+// temperature estimates the correlated color temperature and tint of the regions' average light
+func (t *temperatureFilter) temperature(img background.Background) (float64, float64) {
+	x, y := t.opts.regionStats(img).Chromaticity()
+	kelvin := background.CCT(x, y)
+	return kelvin, background.Duv(x, y, kelvin)
+}
@@ -0,0 +1,206 @@
+package filters
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+type ThemeOptions struct {
+	RegionOptions `yaml:",inline"`
+
+	// Path of the palette file
+	Path string `yaml:"path"`
+
+	// Format of the palette file, pywal, base16 or xresources, detected from the path when unset
+	Format string `yaml:"format"`
+
+	// Reload checks the palette file for changes before every candidate
+	Reload bool `yaml:"reload"`
+
+	// Tolerance is the delta E within which an image color counts as a theme color
+	Tolerance float64 `yaml:"tolerance"`
+
+	// DominantColors is the amount of the image's most common colors compared to the theme
+	DominantColors int `yaml:"dominantColors"`
+
+	// MinCoverage is the fraction of the dominant colors, weighted by share, that must match the theme
+	MinCoverage float64 `yaml:"minCoverage"`
+
+	// IgnoreAccents only matches against the theme's background colors
+	IgnoreAccents bool `yaml:"ignoreAccents"`
+}
+
+func init() {
+	pipeline.AddFilterRegistration("theme", NewThemeFilter)
+}
+
+func NewThemeFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	var options ThemeOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	if err := options.validateRegions(); err != nil {
+		return nil, err
+	}
+
+	if len(options.Path) == 0 {
+		return nil, errors.New("theme filter requires a path")
+	}
+
+	if len(options.Format) == 0 {
+		format, err := detectPaletteFormat(options.Path)
+		if err != nil {
+			return nil, err
+		}
+		options.Format = format
+	}
+
+	// default vals if unset
+	if options.Tolerance == 0 {
+		options.Tolerance = 20
+	}
+	if options.DominantColors == 0 {
+		options.DominantColors = 5
+	}
+	if options.MinCoverage == 0 {
+		options.MinCoverage = 0.6
+	}
+
+	newFilter := &themeFilter{
+		filterLog: filterLog,
+		opts:      &options,
+	}
+
+	if err := newFilter.load(); err != nil {
+		return nil, err
+	}
+
+	return newFilter, nil
+}
+
+type themeFilter struct {
+	filterLog *logrus.Entry
+	opts      *ThemeOptions
+
+	lock     sync.Mutex
+	palette  *palette
+	modified time.Time
+}
+
+func (t *themeFilter) load() error {
+	info, err := os.Stat(t.opts.Path)
+	if err != nil {
+		return err
+	}
+
+	contents, err := ioutil.ReadFile(t.opts.Path)
+	if err != nil {
+		return err
+	}
+
+	loaded, err := parsePalette(t.opts.Format, contents)
+	if err != nil {
+		return err
+	}
+
+	t.palette = loaded
+	t.modified = info.ModTime()
+	t.filterLog.Infof("loaded %d background and %d accent colors from %s", len(loaded.backgrounds), len(loaded.accents), t.opts.Path)
+	return nil
+}
+
+// currentPalette returns the palette, reloading it first if it changed on disk
+// a palette that fails to reload is logged and the previous one kept
+func (t *themeFilter) currentPalette() *palette {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.opts.Reload {
+		if info, err := os.Stat(t.opts.Path); err == nil && !info.ModTime().Equal(t.modified) {
+			if err := t.load(); err != nil {
+				t.filterLog.Warnf("error reloading palette, keeping the previous one: %s", err.Error())
+			}
+		}
+	}
+
+	return t.palette
+}
+
+// IsVolatile is set when reloading, as the palette can change underneath a remembered rejection
+func (t *themeFilter) IsVolatile() bool {
+	return t.opts.Reload
+}
+
+// Fingerprint covers the palette read from the file
+func (t *themeFilter) Fingerprint() string {
+	return fmt.Sprint(t.currentPalette())
+}
+
+func (t *themeFilter) IsValid(img background.Background) *filter.Rejection {
+	coverage := t.coverage(img)
+	t.filterLog.Debugf("theme coverage: %2f", coverage)
+
+	if coverage < t.opts.MinCoverage {
+		return filter.Reject("theme coverage %.3f < minCoverage %.3f", coverage, t.opts.MinCoverage)
+	}
+
+	return nil
+}
+
+// Score rates the background by how much of it matches the theme
+func (t *themeFilter) Score(img background.Background) float64 {
+	return t.coverage(img)
+}
+
+// coverage computes the share weighted fraction of the image's dominant colors
+// that sit within tolerance of a theme color
+func (t *themeFilter) coverage(img background.Background) float64 {
+	current := t.currentPalette()
+	themeColors := append([]background.Lab{}, labs(current.backgrounds)...)
+	if !t.opts.IgnoreAccents {
+		themeColors = append(themeColors, labs(current.accents)...)
+	}
+
+	dominant := t.opts.regionStats(img).DominantColors(t.opts.DominantColors)
+	covered := 0.0
+	total := 0.0
+	for _, d := range dominant {
+		total += d.Share
+
+		nearest := math.MaxFloat64
+		for _, themeColor := range themeColors {
+			nearest = math.Min(nearest, d.Lab.Distance(themeColor))
+		}
+
+		if nearest <= t.opts.Tolerance {
+			covered += d.Share
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return covered / total
+}
+
+func labs(colors []color.NRGBA) []background.Lab {
+	converted := make([]background.Lab, 0, len(colors))
+	for _, c := range colors {
+		converted = append(converted, background.LabFromColor(c))
+	}
+
+	return converted
+}
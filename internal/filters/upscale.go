@@ -0,0 +1,72 @@
+package filters
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"math"
+)
+
+type UpscaleOptions struct {
+	// Width and Height of the output the background has to cover
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
+
+	// MaxUpscale is the largest factor the background may be enlarged by to cover the output
+	MaxUpscale float64 `yaml:"maxUpscale"`
+}
+
+func init() {
+	pipeline.AddFilterRegistration("upscale", NewUpscaleFilter)
+}
+
+func NewUpscaleFilter(config *filter.Configuration, filterLog *logrus.Entry) (filter.Filter, error) {
+	var options UpscaleOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	if options.Width <= 0 || options.Height <= 0 {
+		return nil, errors.New("upscale filter requires a width and height")
+	}
+
+	// default val if unset
+	if options.MaxUpscale == 0 {
+		options.MaxUpscale = 1
+	}
+
+	return &upscaleFilter{
+		filterLog: filterLog,
+		opts:      &options,
+	}, nil
+}
+
+type upscaleFilter struct {
+	filterLog *logrus.Entry
+	opts      *UpscaleOptions
+}
+
+// Phase lets the pipeline check the size before the image is decoded
+func (u *upscaleFilter) Phase() filter.Phase {
+	return filter.PhaseDimensions
+}
+
+func (u *upscaleFilter) IsValid(img background.Background) *filter.Rejection {
+	size, err := img.GetDimensions()
+	if err != nil {
+		return filter.RejectTransient("unable to determine dimensions: %s", err.Error())
+	}
+
+	// the crop keeps the output's aspect ratio, so the tighter axis decides the factor
+	factor := math.Max(float64(u.opts.Width)/float64(size.X), float64(u.opts.Height)/float64(size.Y))
+	u.filterLog.Debugf("upscale factor: %2f", factor)
+
+	if factor > u.opts.MaxUpscale {
+		return filter.Reject("%dx%d needs upscaling by %.2f > maxUpscale %.2f to cover %dx%d", size.X, size.Y, factor, u.opts.MaxUpscale, u.opts.Width, u.opts.Height)
+	}
+
+	return nil
+}
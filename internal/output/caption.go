@@ -0,0 +1,227 @@
+package output
+
+import (
+	"bgfreshd/pkg/background"
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"strings"
+	"text/template"
+)
+
+// caption corners
+const (
+	CornerTopLeft     = "topLeft"
+	CornerTopRight    = "topRight"
+	CornerBottomLeft  = "bottomLeft"
+	CornerBottomRight = "bottomRight"
+)
+
+// caption text colors, auto picks light or dark from what's under the caption
+const (
+	TextAuto  = "auto"
+	TextLight = "light"
+	TextDark  = "dark"
+)
+
+var (
+	lightText = color.NRGBA{R: 245, G: 245, B: 245, A: 255}
+	darkText  = color.NRGBA{R: 20, G: 20, B: 20, A: 255}
+)
+
+type CaptionOptions struct {
+	// Template is a text/template over the background's metadata, IE {{.title}} — u/{{.author}}
+	Template string `yaml:"template"`
+
+	// Font is the path to a TTF or OTF font, defaulting to the bundled Go Regular
+	Font string `yaml:"font"`
+
+	// Size is the font size in output pixels, defaulting to a 50th of the image height
+	Size float64 `yaml:"size"`
+
+	// Corner is where the caption goes, topLeft, topRight, bottomLeft or bottomRight, defaulting to bottomRight
+	Corner string `yaml:"corner"`
+
+	// Margin is the distance in output pixels from the edges, defaulting to the font size
+	Margin int `yaml:"margin"`
+
+	// Color is the text color, auto, light or dark, defaulting to auto
+	Color string `yaml:"color"`
+
+	// BoxOpacity is how opaque the box behind the text is, 0 to 1 where 0 draws no box, defaulting to 0.5
+	BoxOpacity *float64 `yaml:"boxOpacity"`
+}
+
+// Caption draws attribution text built from a background's metadata over its images
+type Caption struct {
+	opts     *CaptionOptions
+	template *template.Template
+	font     *opentype.Font
+}
+
+func NewCaption(options *CaptionOptions) (*Caption, error) {
+	if len(strings.TrimSpace(options.Template)) == 0 {
+		return nil, errors.New("caption requires a template")
+	}
+
+	tmpl, err := template.New("caption").Option("missingkey=zero").Parse(options.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	fontBytes := goregular.TTF
+	if len(options.Font) != 0 {
+		fontBytes, err = ioutil.ReadFile(options.Font)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	parsed, err := opentype.Parse(fontBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse font: %s", err.Error())
+	}
+
+	// default vals if unset
+	if len(options.Corner) == 0 {
+		options.Corner = CornerBottomRight
+	}
+	if len(options.Color) == 0 {
+		options.Color = TextAuto
+	}
+	if options.BoxOpacity == nil {
+		opacity := 0.5
+		options.BoxOpacity = &opacity
+	}
+
+	switch options.Corner {
+	case CornerTopLeft, CornerTopRight, CornerBottomLeft, CornerBottomRight:
+	default:
+		return nil, fmt.Errorf("caption corner \"%s\" is unknown, expected topLeft, topRight, bottomLeft or bottomRight", options.Corner)
+	}
+
+	switch options.Color {
+	case TextAuto, TextLight, TextDark:
+	default:
+		return nil, fmt.Errorf("caption color \"%s\" is unknown, expected auto, light or dark", options.Color)
+	}
+
+	if options.Size < 0 || options.Margin < 0 || *options.BoxOpacity < 0 || *options.BoxOpacity > 1 {
+		return nil, errors.New("caption size and margin must not be negative and boxOpacity must be between 0 and 1")
+	}
+
+	return &Caption{
+		opts:     options,
+		template: tmpl,
+		font:     parsed,
+	}, nil
+}
+
+// Text builds the caption for the metadata, an empty caption means nothing is drawn
+func (c *Caption) Text(meta map[string]string) (string, error) {
+	var buf bytes.Buffer
+	if err := c.template.Execute(&buf, meta); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Draw draws the text over a copy of the image, one line per line of text
+func (c *Caption) Draw(img image.Image, text string) (image.Image, error) {
+	out := copyRGBA(img)
+	bounds := out.Bounds()
+
+	size := c.opts.Size
+	if size == 0 {
+		size = math.Max(12, float64(bounds.Dy())/50)
+	}
+	margin := c.opts.Margin
+	if margin == 0 {
+		margin = int(size)
+	}
+	padding := int(size / 2)
+
+	face, err := opentype.NewFace(c.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	lines := strings.Split(text, "\n")
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil()
+	textWidth := 0
+	for _, line := range lines {
+		textWidth = maxInt(textWidth, font.MeasureString(face, line).Ceil())
+	}
+
+	boxSize := image.Pt(textWidth+2*padding, len(lines)*lineHeight+2*padding)
+	box := image.Rectangle{Min: c.boxOrigin(bounds, boxSize, margin), Max: image.Point{}}
+	box.Max = box.Min.Add(boxSize)
+	box = box.Intersect(bounds)
+
+	textColor, boxColor := darkText, lightText
+	if c.opts.Color == TextLight || (c.opts.Color == TextAuto && meanLuma(out, box) < 0.5) {
+		textColor, boxColor = lightText, darkText
+	}
+
+	if opacity := *c.opts.BoxOpacity; opacity > 0 {
+		boxColor.A = uint8(math.Round(opacity * 255))
+		draw.Draw(out, box, image.NewUniform(boxColor), image.Point{}, draw.Over)
+	}
+
+	drawer := &font.Drawer{Dst: out, Src: image.NewUniform(textColor), Face: face}
+	for i, line := range lines {
+		lineWidth := font.MeasureString(face, line).Ceil()
+		x := box.Min.X + padding
+		if c.opts.Corner == CornerTopRight || c.opts.Corner == CornerBottomRight {
+			// lines hug the edge the caption is anchored to
+			x = box.Max.X - padding - lineWidth
+		}
+
+		drawer.Dot = fixed.P(x, box.Min.Y+padding+i*lineHeight+metrics.Ascent.Ceil())
+		drawer.DrawString(line)
+	}
+
+	return out, nil
+}
+
+func (c *Caption) boxOrigin(bounds image.Rectangle, size image.Point, margin int) image.Point {
+	origin := bounds.Min.Add(image.Pt(margin, margin))
+	if c.opts.Corner == CornerTopRight || c.opts.Corner == CornerBottomRight {
+		origin.X = bounds.Max.X - margin - size.X
+	}
+	if c.opts.Corner == CornerBottomLeft || c.opts.Corner == CornerBottomRight {
+		origin.Y = bounds.Max.Y - margin - size.Y
+	}
+
+	return origin
+}
+
+// meanLuma is the average luma of the rect, sampled on a grid to keep large captions cheap
+func meanLuma(img *image.RGBA, rect image.Rectangle) float64 {
+	step := maxInt(1, maxInt(rect.Dx(), rect.Dy())/64)
+	total, count := 0.0, 0.0
+	for y := rect.Min.Y; y < rect.Max.Y; y += step {
+		for x := rect.Min.X; x < rect.Max.X; x += step {
+			total += background.Luma(pixelAt(img, x, y))
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	return total / count
+}
@@ -0,0 +1,292 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"sort"
+)
+
+// Attribution is the credit embedded in written images
+type Attribution struct {
+	Title   string
+	Creator string
+	Source  string
+}
+
+const (
+	markerSOI  = 0xd8
+	markerSOS  = 0xda
+	markerEOI  = 0xd9
+	markerAPP0 = 0xe0
+	markerAPP1 = 0xe1
+
+	// maxSegmentLength is the most a jpeg segment's length field can describe, the field itself included
+	maxSegmentLength = 0xffff
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// EmbedJpegAttribution writes the attribution into a jpeg as XMP, replacing any XMP it had, and as the EXIF
+// ImageDescription and Artist. images that already carry EXIF have the two merged into it, keeping camera data
+// such as the orientation
+func EmbedJpegAttribution(jpeg []byte, attribution Attribution) ([]byte, error) {
+	if len(jpeg) < 2 || jpeg[0] != 0xff || jpeg[1] != markerSOI {
+		return nil, errors.New("not a jpeg")
+	}
+
+	// segments up to the image data, keeping APP0 at the front where JFIF expects it followed by the EXIF,
+	// as readers stop looking for EXIF at the first other APP1
+	var app0, rest [][]byte
+	var tiff []byte
+	i := 2
+	for {
+		if i+4 > len(jpeg) || jpeg[i] != 0xff {
+			return nil, errors.New("malformed jpeg segment")
+		}
+
+		marker := jpeg[i+1]
+		if marker == markerSOS || marker == markerEOI {
+			break
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(jpeg[i+2:i+4]))
+		if end > len(jpeg) {
+			return nil, errors.New("malformed jpeg segment")
+		}
+
+		segment := jpeg[i:end]
+		payload := segment[4:]
+		switch {
+		case marker == markerAPP0:
+			app0 = append(app0, segment)
+		case marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader):
+			// replaced below
+		case marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) && tiff == nil:
+			merged, err := mergeExifAttribution(payload[len(exifHeader):], attribution)
+			if err != nil {
+				return nil, err
+			}
+			tiff = merged
+		default:
+			rest = append(rest, segment)
+		}
+		i = end
+	}
+
+	var out bytes.Buffer
+	out.Write([]byte{0xff, markerSOI})
+	for _, segment := range app0 {
+		out.Write(segment)
+	}
+
+	if tiff == nil {
+		tiff = exifAttribution(attribution)
+	}
+	if err := writeSegment(&out, markerAPP1, append(append([]byte{}, exifHeader...), tiff...)); err != nil {
+		return nil, err
+	}
+
+	if err := writeSegment(&out, markerAPP1, append(append([]byte{}, xmpHeader...), xmpAttribution(attribution)...)); err != nil {
+		return nil, err
+	}
+
+	for _, segment := range rest {
+		out.Write(segment)
+	}
+	out.Write(jpeg[i:])
+
+	return out.Bytes(), nil
+}
+
+func writeSegment(out *bytes.Buffer, marker byte, payload []byte) error {
+	if len(payload)+2 > maxSegmentLength {
+		return errors.New("attribution is too long to embed")
+	}
+
+	out.Write([]byte{0xff, marker})
+	_ = binary.Write(out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+	return nil
+}
+
+// exifAttribution builds a big endian TIFF structure holding a single IFD with the ImageDescription and Artist
+func exifAttribution(attribution Attribution) []byte {
+	type entry struct {
+		tag   uint16
+		value string
+	}
+
+	var entries []entry
+	if len(attribution.Title) != 0 {
+		entries = append(entries, entry{tag: 0x010e, value: attribution.Title})
+	}
+	if len(attribution.Creator) != 0 {
+		entries = append(entries, entry{tag: 0x013b, value: attribution.Creator})
+	}
+
+	var ifd, data bytes.Buffer
+	ifdSize := 2 + 12*len(entries) + 4
+	dataOffset := 8 + ifdSize
+
+	_ = binary.Write(&ifd, binary.BigEndian, uint16(len(entries)))
+	for _, e := range entries {
+		value := append([]byte(e.value), 0)
+		_ = binary.Write(&ifd, binary.BigEndian, e.tag)
+		_ = binary.Write(&ifd, binary.BigEndian, uint16(2)) // ASCII
+		_ = binary.Write(&ifd, binary.BigEndian, uint32(len(value)))
+
+		// values of up to four bytes are stored in the entry itself
+		if len(value) <= 4 {
+			inline := make([]byte, 4)
+			copy(inline, value)
+			ifd.Write(inline)
+			continue
+		}
+
+		_ = binary.Write(&ifd, binary.BigEndian, uint32(dataOffset+data.Len()))
+		data.Write(value)
+		if data.Len()%2 != 0 {
+			data.WriteByte(0)
+		}
+	}
+	_ = binary.Write(&ifd, binary.BigEndian, uint32(0)) // no next IFD
+
+	var tiff bytes.Buffer
+	tiff.Write([]byte{'M', 'M', 0, 42, 0, 0, 0, 8})
+	tiff.Write(ifd.Bytes())
+	tiff.Write(data.Bytes())
+	return tiff.Bytes()
+}
+
+// mergeExifAttribution sets the ImageDescription and Artist of an existing TIFF structure. rather than rewrite
+// IFD0 in place, which would move every value after it, a copy with the two tags is appended and the header
+// pointed at it, leaving every other offset valid
+func mergeExifAttribution(tiff []byte, attribution Attribution) ([]byte, error) {
+	if len(tiff) < 8 {
+		return nil, errors.New("malformed exif")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("malformed exif byte order")
+	}
+
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset < 8 || ifdOffset+2 > len(tiff) {
+		return nil, errors.New("malformed exif")
+	}
+	count := int(order.Uint16(tiff[ifdOffset:]))
+	entriesEnd := ifdOffset + 2 + 12*count
+	if entriesEnd+4 > len(tiff) {
+		return nil, errors.New("malformed exif")
+	}
+	next := tiff[entriesEnd : entriesEnd+4]
+
+	added := map[uint16]string{}
+	if len(attribution.Title) != 0 {
+		added[0x010e] = attribution.Title
+	}
+	if len(attribution.Creator) != 0 {
+		added[0x013b] = attribution.Creator
+	}
+	if len(added) == 0 {
+		return tiff, nil
+	}
+
+	type entry struct {
+		tag   uint16
+		raw   []byte
+		value string
+	}
+
+	var entries []entry
+	for i := 0; i < count; i++ {
+		raw := tiff[ifdOffset+2+12*i : ifdOffset+2+12*(i+1)]
+		if tag := order.Uint16(raw); len(added[tag]) == 0 {
+			entries = append(entries, entry{tag: tag, raw: raw})
+		}
+	}
+	for tag, value := range added {
+		entries = append(entries, entry{tag: tag, value: value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].tag < entries[j].tag
+	})
+
+	out := bytes.NewBuffer(append([]byte{}, tiff...))
+	if out.Len()%2 != 0 {
+		out.WriteByte(0)
+	}
+	newOffset := out.Len()
+
+	var data bytes.Buffer
+	dataOffset := newOffset + 2 + 12*len(entries) + 4
+	_ = binary.Write(out, order, uint16(len(entries)))
+	for _, e := range entries {
+		if e.raw != nil {
+			out.Write(e.raw)
+			continue
+		}
+
+		value := append([]byte(e.value), 0)
+		_ = binary.Write(out, order, e.tag)
+		_ = binary.Write(out, order, uint16(2)) // ASCII
+		_ = binary.Write(out, order, uint32(len(value)))
+		if len(value) <= 4 {
+			inline := make([]byte, 4)
+			copy(inline, value)
+			out.Write(inline)
+			continue
+		}
+
+		_ = binary.Write(out, order, uint32(dataOffset+data.Len()))
+		data.Write(value)
+		if data.Len()%2 != 0 {
+			data.WriteByte(0)
+		}
+	}
+	out.Write(next)
+	out.Write(data.Bytes())
+
+	merged := out.Bytes()
+	order.PutUint32(merged[4:8], uint32(newOffset))
+	return merged, nil
+}
+
+// xmpAttribution builds an XMP packet with the dublin core title, creator and source
+func xmpAttribution(attribution Attribution) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	buf.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	buf.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	buf.WriteString("  <rdf:Description rdf:about=\"\" xmlns:dc=\"http://purl.org/dc/elements/1.1/\">\n")
+
+	if len(attribution.Title) != 0 {
+		buf.WriteString("   <dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">")
+		_ = xml.EscapeText(&buf, []byte(attribution.Title))
+		buf.WriteString("</rdf:li></rdf:Alt></dc:title>\n")
+	}
+	if len(attribution.Creator) != 0 {
+		buf.WriteString("   <dc:creator><rdf:Seq><rdf:li>")
+		_ = xml.EscapeText(&buf, []byte(attribution.Creator))
+		buf.WriteString("</rdf:li></rdf:Seq></dc:creator>\n")
+	}
+	if len(attribution.Source) != 0 {
+		buf.WriteString("   <dc:source>")
+		_ = xml.EscapeText(&buf, []byte(attribution.Source))
+		buf.WriteString("</dc:source>\n")
+	}
+
+	buf.WriteString("  </rdf:Description>\n </rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"w\"?>")
+	return buf.Bytes()
+}
@@ -0,0 +1,60 @@
+package output
+
+import (
+	"bgfreshd/pkg/background"
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+)
+
+// output formats, original keeps whatever the source served
+const (
+	FormatOriginal = "original"
+	FormatJpeg     = "jpeg"
+	FormatPng      = "png"
+	FormatWebp     = "webp"
+)
+
+// Encode encodes an image written for the background, returning the bytes and the format they're in.
+// the original format passes the source's bytes through untouched when the image wasn't changed,
+// and otherwise re-encodes in the source's format
+func Encode(bg background.Background, img image.Image, changed bool, format string, quality int) ([]byte, string, error) {
+	if format == FormatOriginal || len(format) == 0 {
+		original, originalFormat := bg.GetOriginal()
+		if !changed && original != nil {
+			return original, originalFormat, nil
+		}
+
+		switch originalFormat {
+		case FormatPng, FormatWebp:
+			format = originalFormat
+		default:
+			format = FormatJpeg
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatJpeg:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case FormatPng:
+		err = png.Encode(&buf, img)
+	case FormatWebp:
+		err = EncodeWebp(&buf, img)
+	default:
+		return nil, "", fmt.Errorf("unknown output format %s", format)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), format, nil
+}
+
+// Extension returns the file extension for a format
+func Extension(format string) string {
+	return "." + format
+}
@@ -0,0 +1,268 @@
+package output
+
+import (
+	"bgfreshd/pkg/background"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"math"
+)
+
+// crop anchors, deciding which part of an image survives when its aspect ratio is changed
+const (
+	AnchorEntropy  = "entropy"
+	AnchorSaliency = "saliency"
+	AnchorCenter   = "center"
+)
+
+// cellSize is the size in analysis pixels of the cells the crop heuristics score
+const cellSize = 8
+
+// Fit crops the background to the aspect ratio of width x height around its most interesting part,
+// then scales the crop to exactly width x height. the returned rect is the crop in source pixels
+func Fit(bg background.Background, width int, height int, anchor string) (image.Image, image.Rectangle) {
+	src := bg.GetImage()
+	crop := CropRect(bg, float64(width)/float64(height), anchor)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	return dst, crop
+}
+
+// CropRect finds the largest rect of the given aspect ratio within the background, positioned by the anchor heuristic
+func CropRect(bg background.Background, aspect float64, anchor string) image.Rectangle {
+	bounds := bg.GetImage().Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	cropWidth, cropHeight := width, height
+	if float64(width)/float64(height) > aspect {
+		cropWidth = int(math.Round(float64(height) * aspect))
+	} else {
+		cropHeight = int(math.Round(float64(width) / aspect))
+	}
+
+	// only one axis has room for the crop to move along
+	offset := 0
+	switch {
+	case cropWidth < width:
+		offset = int(bestOffset(bg.GetAnalysis(), true, float64(cropWidth)/float64(width), anchor) * float64(width))
+	case cropHeight < height:
+		offset = int(bestOffset(bg.GetAnalysis(), false, float64(cropHeight)/float64(height), anchor) * float64(height))
+	}
+
+	origin := bounds.Min
+	if cropWidth < width {
+		origin.X += offset
+	} else {
+		origin.Y += offset
+	}
+
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cropWidth, cropHeight))}
+}
+
+// bestOffset slides a window covering fraction of the image along one axis, returning the start
+// of the window holding the most interest as a fraction of the image
+func bestOffset(analysis *background.Analysis, horizontal bool, fraction float64, anchor string) float64 {
+	if anchor == AnchorCenter {
+		return (1 - fraction) / 2
+	}
+
+	profile := interestProfile(analysis, horizontal, anchor)
+	window := int(math.Round(fraction * float64(len(profile))))
+	if window >= len(profile) {
+		return 0
+	}
+
+	sum := 0.0
+	for _, v := range profile[:window] {
+		sum += v
+	}
+
+	// ties keep the window closest to the center
+	best, bestSum := 0, sum
+	center := float64(len(profile)-window) / 2
+	for start := 1; start+window <= len(profile); start++ {
+		sum += profile[start+window-1] - profile[start-1]
+		if sum > bestSum || (sum == bestSum && math.Abs(float64(start)-center) < math.Abs(float64(best)-center)) {
+			best, bestSum = start, sum
+		}
+	}
+
+	// the window was snapped to cells, spread the leftover evenly so the crop stays within the image
+	maxStart := float64(len(profile) - window)
+	return float64(best) / maxStart * (1 - fraction)
+}
+
+// interestProfile scores the cells of the analysis image and sums them along the axis the crop can't move in
+func interestProfile(analysis *background.Analysis, horizontal bool, anchor string) []float64 {
+	img := analysis.Image()
+	bounds := img.Bounds()
+	columns := (bounds.Dx() + cellSize - 1) / cellSize
+	rows := (bounds.Dy() + cellSize - 1) / cellSize
+
+	var score func(cell image.Rectangle) float64
+	if anchor == AnchorSaliency {
+		mean := analysis.Whole().MeanLab()
+		score = func(cell image.Rectangle) float64 {
+			return cellSaliency(img, cell, mean)
+		}
+	} else {
+		score = func(cell image.Rectangle) float64 {
+			return cellEntropy(img, cell)
+		}
+	}
+
+	length := rows
+	if horizontal {
+		length = columns
+	}
+	profile := make([]float64, length)
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			cell := image.Rect(column*cellSize, row*cellSize, (column+1)*cellSize, (row+1)*cellSize).Add(bounds.Min).Intersect(bounds)
+			if horizontal {
+				profile[column] += score(cell)
+			} else {
+				profile[row] += score(cell)
+			}
+		}
+	}
+
+	return profile
+}
+
+// cellEntropy is the shannon entropy of the cell's luma, quantized to 32 levels, busy detail scores high
+func cellEntropy(img *image.RGBA, cell image.Rectangle) float64 {
+	var histogram [32]float64
+	count := 0.0
+	for y := cell.Min.Y; y < cell.Max.Y; y++ {
+		for x := cell.Min.X; x < cell.Max.X; x++ {
+			luma := background.Luma(pixelAt(img, x, y))
+			histogram[int(math.Min(luma*32, 31))]++
+			count++
+		}
+	}
+
+	entropy := 0.0
+	for _, bin := range histogram {
+		if bin != 0 {
+			p := bin / count
+			entropy -= p * math.Log2(p)
+		}
+	}
+
+	return entropy
+}
+
+// cellSaliency is how far the cell's colors stray from the image's average color,
+// parts that stand out from the rest of the image score high
+func cellSaliency(img *image.RGBA, cell image.Rectangle, mean background.Lab) float64 {
+	total := 0.0
+	count := 0.0
+	for y := cell.Min.Y; y < cell.Max.Y; y++ {
+		for x := cell.Min.X; x < cell.Max.X; x++ {
+			total += background.LabFromColor(pixelAt(img, x, y)).Distance(mean)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	return total / count
+}
+
+func pixelAt(img *image.RGBA, x int, y int) color.NRGBA {
+	i := img.PixOffset(x, y)
+	return color.NRGBA{R: img.Pix[i], G: img.Pix[i+1], B: img.Pix[i+2], A: 255}
+}
+
+// FormatRect formats a crop for metadata as x,y,width,height
+func FormatRect(rect image.Rectangle) string {
+	return fmt.Sprintf("%d,%d,%d,%d", rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+}
+
+// Monitor is a single display of a layout, positioned in desktop pixels
+type Monitor struct {
+	Name   string
+	Bounds image.Rectangle
+}
+
+// FitMonitors crops the background to the aspect ratio of the whole layout once, then cuts each monitor's
+// part out of that crop and scales it to the monitor's resolution, so the images line up across displays.
+// the returned images are in the order of the monitors, the rect is the layout's crop in source pixels
+func FitMonitors(bg background.Background, monitors []Monitor, anchor string) ([]image.Image, image.Rectangle) {
+	layout := monitors[0].Bounds
+	for _, m := range monitors[1:] {
+		layout = layout.Union(m.Bounds)
+	}
+
+	src := bg.GetImage()
+	crop := CropRect(bg, float64(layout.Dx())/float64(layout.Dy()), anchor)
+	scaleX := float64(crop.Dx()) / float64(layout.Dx())
+	scaleY := float64(crop.Dy()) / float64(layout.Dy())
+
+	images := make([]image.Image, 0, len(monitors))
+	for _, m := range monitors {
+		offset := m.Bounds.Sub(layout.Min)
+		part := image.Rect(
+			crop.Min.X+int(math.Round(float64(offset.Min.X)*scaleX)),
+			crop.Min.Y+int(math.Round(float64(offset.Min.Y)*scaleY)),
+			crop.Min.X+int(math.Round(float64(offset.Max.X)*scaleX)),
+			crop.Min.Y+int(math.Round(float64(offset.Max.Y)*scaleY)),
+		).Intersect(crop)
+
+		dst := image.NewRGBA(image.Rect(0, 0, m.Bounds.Dx(), m.Bounds.Dy()))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, part, draw.Src, nil)
+		images = append(images, dst)
+	}
+
+	return images, crop
+}
+
+// Orient turns the image the way its EXIF orientation says it's displayed, so it's cropped and written upright.
+// orientations other than 2 through 8 leave the image as it is
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	src := copyRGBA(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// orientations 5 through 8 swap the axes
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			// the source pixel shown at x,y
+			sx, sy := x, y
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx = width - 1 - x
+			case 3: // rotated 180
+				sx, sy = width-1-x, height-1-y
+			case 4: // mirrored vertically
+				sy = height - 1 - y
+			case 5: // mirrored along the top left to bottom right diagonal
+				sx, sy = y, x
+			case 6: // rotated 90 clockwise
+				sx, sy = y, height-1-x
+			case 7: // mirrored along the top right to bottom left diagonal
+				sx, sy = width-1-y, height-1-x
+			case 8: // rotated 90 counter clockwise
+				sx, sy = width-1-y, x
+			}
+
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
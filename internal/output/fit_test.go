@@ -0,0 +1,51 @@
+package output
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// orientSource is stored as
+//
+//	a b c
+//	d e f
+func orientSource() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i, name := range "abcdef" {
+		img.Set(i%3, i/3, color.RGBA{R: uint8(name), A: 0xff})
+	}
+	return img
+}
+
+func TestOrient(t *testing.T) {
+	// how the stored image is displayed for each orientation, row by row
+	tests := map[int][]string{
+		1: {"abc", "def"},
+		2: {"cba", "fed"},
+		3: {"fed", "cba"},
+		4: {"def", "abc"},
+		5: {"ad", "be", "cf"},
+		6: {"da", "eb", "fc"},
+		7: {"fc", "eb", "da"},
+		8: {"cf", "be", "ad"},
+	}
+
+	for orientation, rows := range tests {
+		oriented := Orient(orientSource(), orientation)
+		bounds := oriented.Bounds()
+		if bounds.Dx() != len(rows[0]) || bounds.Dy() != len(rows) {
+			t.Errorf("orientation %d: expected %dx%d, got %dx%d", orientation, len(rows[0]), len(rows), bounds.Dx(), bounds.Dy())
+			continue
+		}
+
+		for y, row := range rows {
+			for x, name := range row {
+				r, _, _, _ := oriented.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				if rune(r>>8) != name {
+					t.Errorf("orientation %d: expected %c at %d,%d, got %c", orientation, name, x, y, rune(r>>8))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package output
+
+import (
+	"bgfreshd/pkg/background"
+	"bytes"
+	"fmt"
+	"image/color"
+	"path"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultFilename names files after the background, as the sources identify it
+const DefaultFilename = "{{.name}}"
+
+// folder shorthands, anything else is used as a template
+const (
+	FolderSource = "source"
+	FolderTag    = "tag"
+)
+
+// maxSlugLength keeps slugs of long titles within filename limits, leaving room for suffixes
+const maxSlugLength = 80
+
+// MaxFilenameLength is the most bytes common filesystems allow in a single path component
+const MaxFilenameLength = 255
+
+// named colors dominant colors are reported as
+var colorNames = []struct {
+	name  string
+	color color.NRGBA
+}{
+	{"black", color.NRGBA{R: 0x10, G: 0x10, B: 0x10, A: 0xff}},
+	{"white", color.NRGBA{R: 0xf4, G: 0xf4, B: 0xf4, A: 0xff}},
+	{"grey", color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff}},
+	{"red", color.NRGBA{R: 0xc8, G: 0x28, B: 0x28, A: 0xff}},
+	{"orange", color.NRGBA{R: 0xe8, G: 0x84, B: 0x24, A: 0xff}},
+	{"yellow", color.NRGBA{R: 0xe8, G: 0xd4, B: 0x38, A: 0xff}},
+	{"green", color.NRGBA{R: 0x38, G: 0x98, B: 0x38, A: 0xff}},
+	{"teal", color.NRGBA{R: 0x28, G: 0x98, B: 0x98, A: 0xff}},
+	{"blue", color.NRGBA{R: 0x28, G: 0x58, B: 0xd0, A: 0xff}},
+	{"purple", color.NRGBA{R: 0x78, G: 0x38, B: 0xb0, A: 0xff}},
+	{"pink", color.NRGBA{R: 0xe0, G: 0x78, B: 0xb0, A: 0xff}},
+	{"brown", color.NRGBA{R: 0x78, G: 0x50, B: 0x30, A: 0xff}},
+}
+
+// Namer builds the folder and filename of a background's files from templates over its metadata
+type Namer struct {
+	filename *template.Template
+	folder   *template.Template
+}
+
+// NewNamer parses the filename and folder templates, an empty filename uses DefaultFilename and an empty folder
+// writes straight to the output path. the folder may be source or tag as a shorthand
+func NewNamer(filename string, folder string) (*Namer, error) {
+	if len(filename) == 0 {
+		filename = DefaultFilename
+	}
+
+	switch folder {
+	case FolderSource:
+		folder = "{{.source}}"
+	case FolderTag:
+		folder = "{{.tag}}"
+	}
+
+	filenameTemplate, err := template.New("filename").Option("missingkey=zero").Parse(filename)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filename template: %s", err.Error())
+	}
+
+	folderTemplate, err := template.New("folder").Option("missingkey=zero").Parse(folder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid folder template: %s", err.Error())
+	}
+
+	return &Namer{filename: filenameTemplate, folder: folderTemplate}, nil
+}
+
+// Name returns the slash separated folder, empty for none, and the filename without extension for the fields.
+// values can't introduce path separators, only slashes written into the folder template itself nest folders
+func (n *Namer) Name(fields map[string]string) (string, string, error) {
+	safe := make(map[string]string, len(fields))
+	for key, val := range fields {
+		safe[key] = sanitizeComponent(val)
+	}
+
+	var buf bytes.Buffer
+	if err := n.folder.Execute(&buf, safe); err != nil {
+		return "", "", err
+	}
+
+	var folders []string
+	for _, part := range strings.Split(buf.String(), "/") {
+		if part = TruncateName(sanitizeComponent(part), MaxFilenameLength); len(part) != 0 {
+			folders = append(folders, part)
+		}
+	}
+
+	buf.Reset()
+	if err := n.filename.Execute(&buf, safe); err != nil {
+		return "", "", err
+	}
+
+	filename := sanitizeComponent(buf.String())
+	if len(filename) == 0 {
+		filename = sanitizeComponent(fields["name"])
+	}
+
+	return path.Join(folders...), TruncateName(filename, MaxFilenameLength), nil
+}
+
+// TruncateName cuts a sanitized name to at most length bytes without splitting a character, trimming the
+// spaces and dots the cut may leave at its end
+func TruncateName(name string, length int) string {
+	if len(name) <= length {
+		return name
+	}
+
+	name = name[:length]
+	for len(name) != 0 && !utf8.ValidString(name) {
+		name = name[:len(name)-1]
+	}
+
+	return strings.TrimRight(name, " .")
+}
+
+// NameFields are the values filename templates see, the background's metadata along with
+// name, source, tag, slug, date, resolution, color and colorHex
+func NameFields(bg background.Background) map[string]string {
+	fields := map[string]string{}
+	for _, key := range bg.GetMetadataKeys() {
+		fields[key] = bg.GetMetadata(key)
+	}
+
+	fields["name"] = bg.GetName()
+	fields["source"] = bg.GetMetadata("source-name")
+	fields["tag"] = bg.GetMetadata("tag")
+	fields["date"] = bg.GetCreatedDate().Format("2006-01-02")
+
+	fields["slug"] = Slugify(bg.GetMetadata("title"))
+	if len(fields["slug"]) == 0 {
+		fields["slug"] = Slugify(bg.GetName())
+	}
+
+	if img := bg.GetImage(); img != nil {
+		fields["resolution"] = fmt.Sprintf("%dx%d", img.Bounds().Dx(), img.Bounds().Dy())
+	}
+
+	if analysis := bg.GetAnalysis(); analysis != nil {
+		if dominant := analysis.Whole().DominantColors(1); len(dominant) != 0 {
+			c := dominant[0].Color
+			fields["color"] = colorName(dominant[0].Lab)
+			fields["colorHex"] = fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
+		}
+	}
+
+	return fields
+}
+
+// Slugify lowercases the text and joins its words with dashes, dropping everything but letters and digits
+func Slugify(text string) string {
+	var buf strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && buf.Len() != 0 {
+				buf.WriteByte('-')
+			}
+			buf.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+
+		if buf.Len() >= maxSlugLength {
+			break
+		}
+	}
+
+	return buf.String()
+}
+
+// sanitizeComponent makes a value safe as a single path component, IE no separators, no commas as files
+// are listed comma separated, and no . or .. components
+func sanitizeComponent(val string) string {
+	val = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ',':
+			return '-'
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, val)
+
+	val = strings.TrimSpace(val)
+	if strings.Trim(val, ".") == "" {
+		return ""
+	}
+
+	return strings.TrimLeft(val, ".")
+}
+
+func colorName(lab background.Lab) string {
+	best, bestDistance := "", -1.0
+	for _, named := range colorNames {
+		if distance := lab.Distance(background.LabFromColor(named.color)); bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = named.name, distance
+		}
+	}
+
+	return best
+}
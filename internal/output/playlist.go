@@ -0,0 +1,51 @@
+package output
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// GnomeSlideshow builds a GNOME background slideshow over the files, showing each for duration seconds including
+// a fade of transition seconds into the next, and looping back to the first. the slideshow starts at start
+func GnomeSlideshow(files []string, duration float64, transition float64, start time.Time) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<background>\n")
+	fmt.Fprintf(&buf, "  <starttime>\n    <year>%d</year>\n    <month>%d</month>\n    <day>%d</day>\n", start.Year(), start.Month(), start.Day())
+	fmt.Fprintf(&buf, "    <hour>%d</hour>\n    <minute>%d</minute>\n    <second>%d</second>\n  </starttime>\n", start.Hour(), start.Minute(), start.Second())
+
+	// a single background is shown for good, transitioning to itself would only flicker
+	if len(files) == 1 {
+		transition = 0
+	}
+
+	for i, file := range files {
+		buf.WriteString("  <static>\n    <duration>")
+		buf.WriteString(formatSeconds(duration - transition))
+		buf.WriteString("</duration>\n    <file>")
+		_ = xml.EscapeText(&buf, []byte(file))
+		buf.WriteString("</file>\n  </static>\n")
+
+		if transition == 0 {
+			continue
+		}
+
+		buf.WriteString("  <transition type=\"overlay\">\n    <duration>")
+		buf.WriteString(formatSeconds(transition))
+		buf.WriteString("</duration>\n    <from>")
+		_ = xml.EscapeText(&buf, []byte(file))
+		buf.WriteString("</from>\n    <to>")
+		_ = xml.EscapeText(&buf, []byte(files[(i+1)%len(files)]))
+		buf.WriteString("</to>\n  </transition>\n")
+	}
+
+	buf.WriteString("</background>\n")
+	return buf.Bytes()
+}
+
+// formatSeconds writes seconds the way GNOME's own slideshows do, IE 1795.0
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 1, 64)
+}
@@ -0,0 +1,350 @@
+package output
+
+import (
+	"bgfreshd/internal"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Transform adjusts a rendered image before it's encoded
+type Transform interface {
+	Apply(img *image.RGBA) *image.RGBA
+}
+
+// TransformConfiguration describes a single transform and its options
+type TransformConfiguration struct {
+	Type    string                 `yaml:"type"`
+	Options map[string]interface{} `yaml:"options"`
+}
+
+type BrightnessOptions struct {
+	// Brightness is added to every channel, -1 turns the image black and 1 white
+	Brightness float64 `yaml:"brightness"`
+
+	// Contrast scales the channels away from or towards mid grey, -1 flattens the image to grey
+	Contrast float64 `yaml:"contrast"`
+}
+
+type BlurOptions struct {
+	// Radius is the standard deviation of the gaussian in output pixels
+	Radius float64 `yaml:"radius"`
+}
+
+type TintOptions struct {
+	// Color is the hex color tinted toward, IE #203040
+	Color string `yaml:"color"`
+
+	// Amount is how far toward the color, 0 to 1
+	Amount float64 `yaml:"amount"`
+}
+
+type VignetteOptions struct {
+	// Strength is how dark the corners get, 0 to 1
+	Strength float64 `yaml:"strength"`
+
+	// Radius is the distance from the center, as a fraction of the distance to the corners, where darkening begins
+	Radius float64 `yaml:"radius"`
+}
+
+type DesaturateOptions struct {
+	// Amount is how far toward grey, 0 to 1
+	Amount float64 `yaml:"amount"`
+}
+
+// NewTransforms creates the transforms in the order they're listed
+func NewTransforms(configs []TransformConfiguration) ([]Transform, error) {
+	transforms := make([]Transform, 0, len(configs))
+	for i, conf := range configs {
+		transform, err := newTransform(conf)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d (%s): %s", i, conf.Type, err.Error())
+		}
+		transforms = append(transforms, transform)
+	}
+
+	return transforms, nil
+}
+
+func newTransform(conf TransformConfiguration) (Transform, error) {
+	switch conf.Type {
+	case "brightness":
+		var options BrightnessOptions
+		if err := internal.CastDecodedYamlToType(conf.Options, &options); err != nil {
+			return nil, err
+		}
+		if options.Brightness < -1 || options.Brightness > 1 || options.Contrast < -1 {
+			return nil, errors.New("brightness must be between -1 and 1 and contrast at least -1")
+		}
+		return &brightnessTransform{opts: &options}, nil
+	case "blur":
+		var options BlurOptions
+		if err := internal.CastDecodedYamlToType(conf.Options, &options); err != nil {
+			return nil, err
+		}
+		if options.Radius <= 0 {
+			return nil, errors.New("blur requires a positive radius")
+		}
+		return &blurTransform{opts: &options}, nil
+	case "tint":
+		var options TintOptions
+		if err := internal.CastDecodedYamlToType(conf.Options, &options); err != nil {
+			return nil, err
+		}
+		// go-yaml doesn't quote strings starting with # when marshaling, so the color is lost in the cast above
+		if hex, ok := conf.Options["color"].(string); ok {
+			options.Color = hex
+		}
+		tint, err := parseHexColor(options.Color)
+		if err != nil {
+			return nil, err
+		}
+		if options.Amount < 0 || options.Amount > 1 {
+			return nil, errors.New("tint amount must be between 0 and 1")
+		}
+		return &tintTransform{opts: &options, color: tint}, nil
+	case "vignette":
+		var options VignetteOptions
+		if err := internal.CastDecodedYamlToType(conf.Options, &options); err != nil {
+			return nil, err
+		}
+		// default vals if unset
+		if options.Strength == 0 {
+			options.Strength = 0.5
+		}
+		if options.Radius == 0 {
+			options.Radius = 0.5
+		}
+		if options.Strength < 0 || options.Strength > 1 || options.Radius < 0 || options.Radius >= 1 {
+			return nil, errors.New("vignette strength must be between 0 and 1 and radius between 0 and 1")
+		}
+		return &vignetteTransform{opts: &options}, nil
+	case "desaturate":
+		var options DesaturateOptions
+		if err := internal.CastDecodedYamlToType(conf.Options, &options); err != nil {
+			return nil, err
+		}
+		// default val if unset
+		if options.Amount == 0 {
+			options.Amount = 1
+		}
+		if options.Amount < 0 || options.Amount > 1 {
+			return nil, errors.New("desaturate amount must be between 0 and 1")
+		}
+		return &desaturateTransform{opts: &options}, nil
+	}
+
+	return nil, fmt.Errorf("unknown transform type %s", conf.Type)
+}
+
+// ApplyTransforms runs the transforms in order over a copy of the image, leaving img untouched
+func ApplyTransforms(img image.Image, transforms []Transform) image.Image {
+	if len(transforms) == 0 {
+		return img
+	}
+
+	out := copyRGBA(img)
+	for _, transform := range transforms {
+		out = transform.Apply(out)
+	}
+
+	return out
+}
+
+// copyRGBA copies the image into a new RGBA image with its origin at 0,0
+func copyRGBA(img image.Image) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Src)
+	return out
+}
+
+type brightnessTransform struct {
+	opts *BrightnessOptions
+}
+
+func (b *brightnessTransform) Apply(img *image.RGBA) *image.RGBA {
+	var lookup [256]uint8
+	for i := range lookup {
+		v := (float64(i)/255-0.5)*(1+b.opts.Contrast) + 0.5 + b.opts.Brightness
+		lookup[i] = toByte(v)
+	}
+
+	mapChannels(img, func(r, g, b uint8) (uint8, uint8, uint8) {
+		return lookup[r], lookup[g], lookup[b]
+	})
+	return img
+}
+
+type tintTransform struct {
+	opts  *TintOptions
+	color color.NRGBA
+}
+
+func (t *tintTransform) Apply(img *image.RGBA) *image.RGBA {
+	amount := t.opts.Amount
+	mapChannels(img, func(r, g, b uint8) (uint8, uint8, uint8) {
+		return mix(r, t.color.R, amount), mix(g, t.color.G, amount), mix(b, t.color.B, amount)
+	})
+	return img
+}
+
+type desaturateTransform struct {
+	opts *DesaturateOptions
+}
+
+func (d *desaturateTransform) Apply(img *image.RGBA) *image.RGBA {
+	amount := d.opts.Amount
+	mapChannels(img, func(r, g, b uint8) (uint8, uint8, uint8) {
+		grey := uint8(math.Round(0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)))
+		return mix(r, grey, amount), mix(g, grey, amount), mix(b, grey, amount)
+	})
+	return img
+}
+
+type vignetteTransform struct {
+	opts *VignetteOptions
+}
+
+func (v *vignetteTransform) Apply(img *image.RGBA) *image.RGBA {
+	bounds := img.Bounds()
+	centerX := float64(bounds.Min.X+bounds.Max.X) / 2
+	centerY := float64(bounds.Min.Y+bounds.Max.Y) / 2
+	corner := math.Hypot(float64(bounds.Dx())/2, float64(bounds.Dy())/2)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			distance := math.Hypot(float64(x)+0.5-centerX, float64(y)+0.5-centerY) / corner
+			if distance <= v.opts.Radius {
+				continue
+			}
+
+			// smoothstep from the radius out to the corners
+			t := math.Min(1, (distance-v.opts.Radius)/(1-v.opts.Radius))
+			factor := 1 - v.opts.Strength*t*t*(3-2*t)
+
+			i := img.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				img.Pix[i+c] = uint8(float64(img.Pix[i+c]) * factor)
+			}
+		}
+	}
+
+	return img
+}
+
+// blurTransform approximates a gaussian blur with three box blurs, which costs the same whatever the radius
+type blurTransform struct {
+	opts *BlurOptions
+}
+
+func (b *blurTransform) Apply(img *image.RGBA) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	tmp := image.NewRGBA(bounds)
+
+	for _, size := range boxSizes(b.opts.Radius, 3) {
+		radius := (size - 1) / 2
+		boxBlur(img.Pix, tmp.Pix, width, height, 4, img.Stride, radius)
+		boxBlur(tmp.Pix, img.Pix, height, width, img.Stride, 4, radius)
+	}
+
+	return img
+}
+
+// boxSizes returns the widths of n box blurs whose combination approximates a gaussian of the given sigma
+func boxSizes(sigma float64, n int) []int {
+	ideal := math.Sqrt(12*sigma*sigma/float64(n) + 1)
+	lower := int(math.Floor(ideal))
+	if lower%2 == 0 {
+		lower--
+	}
+	upper := lower + 2
+
+	m := int(math.Round((12*sigma*sigma - float64(n*lower*lower) - float64(4*n*lower) - float64(3*n)) / float64(-4*lower-4)))
+	sizes := make([]int, n)
+	for i := range sizes {
+		if i < m {
+			sizes[i] = lower
+		} else {
+			sizes[i] = upper
+		}
+	}
+
+	return sizes
+}
+
+// boxBlur averages each line of src with a running sum over radius pixels either side, writing to dst.
+// step is the distance between neighbouring pixels of a line and lineStep between lines, so the same
+// function blurs rows and columns. edges are extended
+func boxBlur(src []uint8, dst []uint8, length int, lines int, step int, lineStep int, radius int) {
+	if radius < 1 {
+		copy(dst, src)
+		return
+	}
+
+	window := float64(2*radius + 1)
+	for line := 0; line < lines; line++ {
+		start := line * lineStep
+		at := func(i int) int {
+			if i < 0 {
+				i = 0
+			} else if i >= length {
+				i = length - 1
+			}
+			return start + i*step
+		}
+
+		for c := 0; c < 3; c++ {
+			sum := 0
+			for i := -radius - 1; i < radius; i++ {
+				sum += int(src[at(i)+c])
+			}
+
+			for i := 0; i < length; i++ {
+				sum += int(src[at(i+radius)+c]) - int(src[at(i-radius-1)+c])
+				dst[start+i*step+c] = uint8(math.Round(float64(sum) / window))
+			}
+		}
+		for i := 0; i < length; i++ {
+			dst[start+i*step+3] = src[start+i*step+3]
+		}
+	}
+}
+
+func mapChannels(img *image.RGBA, fn func(r, g, b uint8) (uint8, uint8, uint8)) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := img.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2] = fn(img.Pix[i], img.Pix[i+1], img.Pix[i+2])
+			i += 4
+		}
+	}
+}
+
+func mix(from uint8, to uint8, amount float64) uint8 {
+	return uint8(math.Round(float64(from) + (float64(to)-float64(from))*amount))
+}
+
+func toByte(v float64) uint8 {
+	return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+}
+
+func parseHexColor(hex string) (color.NRGBA, error) {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid color \"%s\", expected #rrggbb", hex)
+	}
+
+	val, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color \"%s\", expected #rrggbb", hex)
+	}
+
+	return color.NRGBA{R: uint8(val >> 16), G: uint8(val >> 8), B: uint8(val), A: 255}, nil
+}
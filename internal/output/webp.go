@@ -0,0 +1,610 @@
+package output
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// EncodeWebp writes img as a lossless WebP (VP8L) image
+// the encoder keeps to a small part of the format: the subtract green and predictor transforms,
+// backward references to the pixel to the left or above, and a single set of prefix codes
+func EncodeWebp(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > webpMaxSize || height > webpMaxSize {
+		return fmt.Errorf("webp images must be between 1x1 and %dx%d, got %dx%d", webpMaxSize, webpMaxSize, width, height)
+	}
+
+	argb := toARGB(img)
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.write(0, 1) // alpha is unused, backgrounds are opaque
+	bw.write(0, 3) // version
+
+	// subtract green
+	bw.write(1, 1)
+	bw.write(webpSubtractGreen, 2)
+	for i, p := range argb {
+		green := (p >> 8) & 0xff
+		argb[i] = p&0xff00ff00 | ((p>>16-green)&0xff)<<16 | ((p - green) & 0xff)
+	}
+
+	// predictor
+	bw.write(1, 1)
+	bw.write(webpPredictor, 2)
+	bw.write(webpTileBits-2, 3)
+	modes, tilesWide := choosePredictors(argb, width, height)
+	writeEntropyImage(bw, modes, tilesWide, false)
+	residuals := predictResiduals(argb, width, height, modes, tilesWide)
+
+	// no more transforms
+	bw.write(0, 1)
+	writeEntropyImage(bw, residuals, width, true)
+
+	payload := bw.bytes()
+	padded := len(payload) + len(payload)&1
+	header := make([]byte, 20)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(4+8+padded))
+	copy(header[8:12], "WEBP")
+	copy(header[12:16], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:20], uint32(len(payload)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	if padded != len(payload) {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	webpMaxSize = 16384
+
+	webpPredictor     = 0
+	webpSubtractGreen = 2
+
+	// predictor modes are chosen per 32x32 tile
+	webpTileBits = 5
+
+	webpLengthCodes   = 24
+	webpDistanceCodes = 40
+	webpMaxLength     = 4096
+	webpMinLength     = 3
+
+	// distance codes of the neighbourhood table for the pixel above and the pixel to the left
+	webpDistanceAbove = 1
+	webpDistanceLeft  = 2
+)
+
+// predictor modes the encoder picks between, as numbered by the format
+var webpModes = []uint32{1, 2, 7, 11, 12}
+
+// kCodeLengthCodeOrder from the format, the order the code length code lengths are written in
+var codeLengthCodeOrder = []int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+func toARGB(img image.Image) []uint32 {
+	bounds := img.Bounds()
+	argb := make([]uint32, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			argb = append(argb, 0xff000000|uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+		}
+	}
+
+	return argb
+}
+
+// choosePredictors picks the mode per tile with the smallest residuals, returning the modes as the
+// predictor sub-image, where the green channel holds the mode
+func choosePredictors(argb []uint32, width int, height int) ([]uint32, int) {
+	tileSize := 1 << webpTileBits
+	tilesWide := (width + tileSize - 1) / tileSize
+	tilesHigh := (height + tileSize - 1) / tileSize
+	modes := make([]uint32, tilesWide*tilesHigh)
+
+	for ty := 0; ty < tilesHigh; ty++ {
+		for tx := 0; tx < tilesWide; tx++ {
+			bestMode, bestCost := webpModes[0], -1
+			for _, mode := range webpModes {
+				cost := 0
+				for y := ty * tileSize; y < minInt((ty+1)*tileSize, height); y++ {
+					for x := tx * tileSize; x < minInt((tx+1)*tileSize, width); x++ {
+						cost += residualCost(argb[y*width+x], predict(argb, width, x, y, mode))
+					}
+				}
+
+				if bestCost < 0 || cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+
+			modes[ty*tilesWide+tx] = 0xff000000 | bestMode<<8
+		}
+	}
+
+	return modes, tilesWide
+}
+
+func predictResiduals(argb []uint32, width int, height int, modes []uint32, tilesWide int) []uint32 {
+	residuals := make([]uint32, len(argb))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			mode := (modes[(y>>webpTileBits)*tilesWide+(x>>webpTileBits)] >> 8) & 0xf
+			residuals[y*width+x] = subPixels(argb[y*width+x], predict(argb, width, x, y, mode))
+		}
+	}
+
+	return residuals
+}
+
+// predict returns the prediction for the pixel, the top row and left column use fixed predictors
+func predict(argb []uint32, width int, x int, y int, mode uint32) uint32 {
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[x-1]
+	case x == 0:
+		return argb[(y-1)*width]
+	}
+
+	left := argb[y*width+x-1]
+	top := argb[(y-1)*width+x]
+	topLeft := argb[(y-1)*width+x-1]
+	switch mode {
+	case 1:
+		return left
+	case 2:
+		return top
+	case 7:
+		return average2(left, top)
+	case 11:
+		return selectPredictor(left, top, topLeft)
+	case 12:
+		return clampAddSubtractFull(left, top, topLeft)
+	}
+
+	return 0xff000000
+}
+
+func channel(p uint32, shift uint) int {
+	return int((p >> shift) & 0xff)
+}
+
+func average2(a uint32, b uint32) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		out |= uint32((channel(a, shift)+channel(b, shift))/2) << shift
+	}
+	return out
+}
+
+func selectPredictor(left uint32, top uint32, topLeft uint32) uint32 {
+	distanceLeft, distanceTop := 0, 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		estimate := channel(left, shift) + channel(top, shift) - channel(topLeft, shift)
+		distanceLeft += absInt(estimate - channel(left, shift))
+		distanceTop += absInt(estimate - channel(top, shift))
+	}
+
+	if distanceLeft < distanceTop {
+		return left
+	}
+	return top
+}
+
+func clampAddSubtractFull(left uint32, top uint32, topLeft uint32) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		v := channel(left, shift) + channel(top, shift) - channel(topLeft, shift)
+		out |= uint32(clampByte(v)) << shift
+	}
+	return out
+}
+
+func subPixels(a uint32, b uint32) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		out |= uint32((channel(a, shift)-channel(b, shift))&0xff) << shift
+	}
+	return out
+}
+
+// residualCost approximates how expensive a residual is to store by its distance from zero
+func residualCost(pixel uint32, prediction uint32) int {
+	residual := subPixels(pixel, prediction)
+	cost := 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		cost += absInt(int(int8(channel(residual, shift))))
+	}
+	return cost
+}
+
+// webpSymbol is a literal pixel or a backward reference
+type webpSymbol struct {
+	pixel    uint32
+	length   int
+	distance int
+}
+
+// writeEntropyImage writes the pixels with a single set of prefix codes, the main image additionally
+// signals that it uses no meta prefix codes
+func writeEntropyImage(bw *bitWriter, pixels []uint32, width int, main bool) {
+	bw.write(0, 1) // no color cache
+	if main {
+		bw.write(0, 1) // no meta prefix codes
+	}
+
+	symbols := backwardReferences(pixels, width)
+
+	green := make([]int, 256+webpLengthCodes)
+	red := make([]int, 256)
+	blue := make([]int, 256)
+	alpha := make([]int, 256)
+	distance := make([]int, webpDistanceCodes)
+	for _, s := range symbols {
+		if s.length == 0 {
+			green[channel(s.pixel, 8)]++
+			red[channel(s.pixel, 16)]++
+			blue[channel(s.pixel, 0)]++
+			alpha[channel(s.pixel, 24)]++
+			continue
+		}
+
+		lengthCode, _, _ := prefixEncode(s.length)
+		distanceCode, _, _ := prefixEncode(s.distance)
+		green[256+lengthCode]++
+		distance[distanceCode]++
+	}
+
+	codes := []*prefixCode{
+		newPrefixCode(green, 15),
+		newPrefixCode(red, 15),
+		newPrefixCode(blue, 15),
+		newPrefixCode(alpha, 15),
+		newPrefixCode(distance, 15),
+	}
+	for _, code := range codes {
+		code.writeTo(bw)
+	}
+
+	for _, s := range symbols {
+		if s.length == 0 {
+			codes[0].writeSymbol(bw, channel(s.pixel, 8))
+			codes[1].writeSymbol(bw, channel(s.pixel, 16))
+			codes[2].writeSymbol(bw, channel(s.pixel, 0))
+			codes[3].writeSymbol(bw, channel(s.pixel, 24))
+			continue
+		}
+
+		lengthCode, lengthBits, lengthExtra := prefixEncode(s.length)
+		codes[0].writeSymbol(bw, 256+lengthCode)
+		bw.write(lengthExtra, lengthBits)
+
+		distanceCode, distanceBits, distanceExtra := prefixEncode(s.distance)
+		codes[4].writeSymbol(bw, distanceCode)
+		bw.write(distanceExtra, distanceBits)
+	}
+}
+
+// backwardReferences greedily replaces runs that repeat the pixel to the left or the row above with copies
+func backwardReferences(pixels []uint32, width int) []webpSymbol {
+	symbols := make([]webpSymbol, 0, len(pixels)/2)
+	for i := 0; i < len(pixels); {
+		leftRun := matchLength(pixels, i, 1)
+		aboveRun := 0
+		if i >= width {
+			aboveRun = matchLength(pixels, i, width)
+		}
+
+		switch {
+		case aboveRun >= webpMinLength && aboveRun >= leftRun:
+			symbols = append(symbols, webpSymbol{length: aboveRun, distance: webpDistanceAbove})
+			i += aboveRun
+		case leftRun >= webpMinLength:
+			symbols = append(symbols, webpSymbol{length: leftRun, distance: webpDistanceLeft})
+			i += leftRun
+		default:
+			symbols = append(symbols, webpSymbol{pixel: pixels[i]})
+			i++
+		}
+	}
+
+	return symbols
+}
+
+func matchLength(pixels []uint32, i int, distance int) int {
+	if i < distance {
+		return 0
+	}
+
+	length := 0
+	for i+length < len(pixels) && length < webpMaxLength && pixels[i+length] == pixels[i+length-distance] {
+		length++
+	}
+	return length
+}
+
+// prefixEncode splits a length or distance code into its prefix symbol and the extra bits that follow it
+func prefixEncode(value int) (int, uint, uint32) {
+	n := value - 1
+	if n < 4 {
+		return n, 0, 0
+	}
+
+	highest := 0
+	for (n >> uint(highest+1)) != 0 {
+		highest++
+	}
+
+	second := (n >> uint(highest-1)) & 1
+	extraBits := uint(highest - 1)
+	return 2*highest + second, extraBits, uint32(n) & (1<<extraBits - 1)
+}
+
+// prefixCode is a canonical huffman code over an alphabet
+type prefixCode struct {
+	lengths []int
+	codes   []uint32
+	used    []int
+}
+
+func newPrefixCode(counts []int, maxLength int) *prefixCode {
+	code := &prefixCode{
+		lengths: make([]int, len(counts)),
+		codes:   make([]uint32, len(counts)),
+	}
+
+	for symbol, count := range counts {
+		if count != 0 {
+			code.used = append(code.used, symbol)
+		}
+	}
+
+	// one or no symbols are written with the simple code and take no bits per symbol
+	if len(code.used) < 2 {
+		return code
+	}
+
+	code.lengths = huffmanLengths(counts, maxLength)
+	code.assignCodes()
+	return code
+}
+
+// assignCodes gives each symbol its canonical code, bit reversed as the format reads codes least significant bit first
+func (p *prefixCode) assignCodes() {
+	maxLength := 0
+	for _, length := range p.lengths {
+		maxLength = maxInt(maxLength, length)
+	}
+
+	lengthCounts := make([]int, maxLength+1)
+	for _, length := range p.lengths {
+		if length != 0 {
+			lengthCounts[length]++
+		}
+	}
+
+	next := make([]uint32, maxLength+1)
+	code := uint32(0)
+	for length := 1; length <= maxLength; length++ {
+		code = (code + uint32(lengthCounts[length-1])) << 1
+		next[length] = code
+	}
+
+	for symbol, length := range p.lengths {
+		if length == 0 {
+			continue
+		}
+
+		p.codes[symbol] = reverseBits(next[length], length)
+		next[length]++
+	}
+}
+
+func (p *prefixCode) writeTo(bw *bitWriter) {
+	if len(p.used) < 2 {
+		symbol := 0
+		if len(p.used) == 1 {
+			symbol = p.used[0]
+		}
+
+		bw.write(1, 1) // simple code
+		bw.write(0, 1) // a single symbol
+		if symbol < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(symbol), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(symbol), 8)
+		}
+		return
+	}
+
+	bw.write(0, 1) // normal code
+
+	// the code lengths are themselves written with a prefix code over the lengths 0..15
+	lengthCounts := make([]int, 19)
+	for _, length := range p.lengths {
+		lengthCounts[length]++
+	}
+	lengthCode := newPrefixCode(lengthCounts, 7)
+	if len(lengthCode.used) == 1 {
+		// every symbol has the same length, a lone length code is read with zero bits
+		lengthCode.lengths[lengthCode.used[0]] = 1
+	}
+
+	count := len(codeLengthCodeOrder)
+	for count > 4 && lengthCode.lengths[codeLengthCodeOrder[count-1]] == 0 {
+		count--
+	}
+
+	bw.write(uint32(count-4), 4)
+	for _, symbol := range codeLengthCodeOrder[:count] {
+		bw.write(uint32(lengthCode.lengths[symbol]), 3)
+	}
+
+	bw.write(0, 1) // every symbol's length follows
+	for _, length := range p.lengths {
+		lengthCode.writeSymbol(bw, length)
+	}
+}
+
+func (p *prefixCode) writeSymbol(bw *bitWriter, symbol int) {
+	if len(p.used) < 2 {
+		return
+	}
+
+	bw.write(p.codes[symbol], uint(p.lengths[symbol]))
+}
+
+// huffmanLengths builds huffman code lengths no longer than maxLength, flattening the
+// counts until the tree is shallow enough
+func huffmanLengths(counts []int, maxLength int) []int {
+	for minCount := 1; ; minCount *= 2 {
+		lengths := treeDepths(counts, minCount)
+		longest := 0
+		for _, length := range lengths {
+			longest = maxInt(longest, length)
+		}
+
+		if longest <= maxLength {
+			return lengths
+		}
+	}
+}
+
+type huffmanNode struct {
+	count  int
+	symbol int
+	left   *huffmanNode
+	right  *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h huffmanHeap) Swap(i, j int)          { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(node interface{}) { *h = append(*h, node.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	node := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return node
+}
+
+func treeDepths(counts []int, minCount int) []int {
+	nodes := &huffmanHeap{}
+	for symbol, count := range counts {
+		if count != 0 {
+			*nodes = append(*nodes, &huffmanNode{count: maxInt(count, minCount), symbol: symbol})
+		}
+	}
+	heap.Init(nodes)
+
+	for nodes.Len() > 1 {
+		a := heap.Pop(nodes).(*huffmanNode)
+		b := heap.Pop(nodes).(*huffmanNode)
+		heap.Push(nodes, &huffmanNode{count: a.count + b.count, symbol: minInt(a.symbol, b.symbol), left: a, right: b})
+	}
+
+	lengths := make([]int, len(counts))
+	var walk func(node *huffmanNode, depth int)
+	walk = func(node *huffmanNode, depth int) {
+		if node.left == nil {
+			lengths[node.symbol] = depth
+			return
+		}
+		walk(node.left, depth+1)
+		walk(node.right, depth+1)
+	}
+	walk((*nodes)[0], 0)
+
+	return lengths
+}
+
+func reverseBits(code uint32, length int) uint32 {
+	var reversed uint32
+	for i := 0; i < length; i++ {
+		reversed = reversed<<1 | (code>>uint(i))&1
+	}
+	return reversed
+}
+
+// bitWriter packs values least significant bit first
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	count uint
+}
+
+func (b *bitWriter) write(value uint32, bits uint) {
+	b.acc |= uint64(value&(1<<bits-1)) << b.count
+	b.count += bits
+	for b.count >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.count -= 8
+	}
+}
+
+func (b *bitWriter) bytes() []byte {
+	if b.count > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc = 0
+		b.count = 0
+	}
+	return b.buf
+}
+
+func clampByte(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
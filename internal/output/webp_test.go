@@ -0,0 +1,117 @@
+package output
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func randomImage(width int, height int, seed int64) image.Image {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
+
+func gradientImage(width int, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: uint8((x + y) % 256), A: 0xff})
+		}
+	}
+	return img
+}
+
+func flatImage(width int, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{0x30, 0x80, 0xc0, 0xff})
+	}
+	return img
+}
+
+// stripesImage repeats short runs along rows and columns, so backward references are used heavily
+func stripesImage(width int, height int) image.Image {
+	palette := []color.RGBA{{R: 0xff, A: 0xff}, {G: 0xff, A: 0xff}, {B: 0xff, A: 0xff}, {R: 0x10, G: 0x20, B: 0x30, A: 0xff}}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, palette[(x/3+y/5)%len(palette)])
+		}
+	}
+	return img
+}
+
+// offsetImage has bounds that don't start at the origin, as crops of decoded images do
+func offsetImage() image.Image {
+	return randomImage(90, 70, 7).(*image.NRGBA).SubImage(image.Rect(13, 9, 80, 60))
+}
+
+func TestEncodeWebpRoundtrip(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"random", randomImage(64, 64, 1)},
+		{"random odd", randomImage(97, 31, 2)},
+		{"random large", randomImage(300, 200, 3)},
+		{"gradient", gradientImage(256, 128)},
+		{"gradient odd", gradientImage(33, 65)},
+		{"flat", flatImage(128, 96)},
+		{"flat odd", flatImage(17, 3)},
+		{"stripes", stripesImage(129, 77)},
+		{"single pixel", randomImage(1, 1, 4)},
+		{"single row", gradientImage(1000, 1)},
+		{"single column", gradientImage(1, 333)},
+		{"offset bounds", offsetImage()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeWebp(&buf, test.img); err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("decoding: %s", err)
+			}
+
+			assertSamePixels(t, test.img, decoded)
+		})
+	}
+}
+
+func TestEncodeWebpRejectsEmpty(t *testing.T) {
+	if err := EncodeWebp(&bytes.Buffer{}, image.NewRGBA(image.Rect(0, 0, 0, 5))); err == nil {
+		t.Error("expected an empty image to be rejected")
+	}
+}
+
+func assertSamePixels(t *testing.T, expected image.Image, got image.Image) {
+	t.Helper()
+
+	eb, gb := expected.Bounds(), got.Bounds()
+	if eb.Dx() != gb.Dx() || eb.Dy() != gb.Dy() {
+		t.Fatalf("expected %dx%d, got %dx%d", eb.Dx(), eb.Dy(), gb.Dx(), gb.Dy())
+	}
+
+	for y := 0; y < eb.Dy(); y++ {
+		for x := 0; x < eb.Dx(); x++ {
+			e := color.NRGBAModel.Convert(expected.At(eb.Min.X+x, eb.Min.Y+y)).(color.NRGBA)
+			g := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+			if e != g {
+				t.Fatalf("pixel %d,%d: expected %v, got %v", x, y, e, g)
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@
 import (
 	"bgfreshd/internal/db"
 	"bgfreshd/pkg/filter"
+	"bgfreshd/pkg/sink"
 	"bgfreshd/pkg/source"
 	"fmt"
 	"github.com/sirupsen/logrus"
@@ -10,6 +11,7 @@
 
 var filterRegistrations map[string]filter.FactoryFunc
 var sourceRegistrations map[string]source.FactoryFunc
+var sinkRegistrations map[string]sink.FactoryFunc
 
 func AddFilterRegistration(name string, factoryFunc filter.FactoryFunc) {
 	if filterRegistrations == nil {
@@ -26,6 +28,14 @@ func AddSourceRegistration(name string, factoryFunc source.FactoryFunc) {
 	sourceRegistrations[name] = factoryFunc
 }
 
+func AddSinkRegistration(name string, factoryFunc sink.FactoryFunc) {
+	if sinkRegistrations == nil {
+		sinkRegistrations = make(map[string]sink.FactoryFunc)
+	}
+
+	sinkRegistrations[name] = factoryFunc
+}
+
 type FilterNotFoundError struct {
 	filterName string
 }
@@ -76,3 +86,26 @@ func(meta string) (source.Db, error) {
 		},
 		individualLog)
 }
+
+type SinkNotFoundError struct {
+	sinkName string
+}
+
+func (s *SinkNotFoundError) Error() string {
+	return fmt.Sprintf("sink %s not found", s.sinkName)
+}
+
+func CreateSink(config *sink.Configuration, sinkLog *logrus.Entry) (sink.OutputSink, error) {
+	sinkLog.Infof("Constructing sink of type %s", config.Type)
+	individualLog := sinkLog.WithFields(logrus.Fields{
+		"sink": config.Type,
+	})
+
+	factory, ok := sinkRegistrations[config.Type]
+	if !ok {
+		sinkLog.Errorf("Could not find sink of type %s", config.Type)
+		return nil, &SinkNotFoundError{sinkName: config.Type}
+	}
+
+	return factory(config, individualLog)
+}
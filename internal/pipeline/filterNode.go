@@ -3,25 +3,74 @@
 import (
 	"bgfreshd/pkg/background"
 	"bgfreshd/pkg/filter"
+	"crypto/sha1"
+	"fmt"
 	"github.com/sirupsen/logrus"
+	"math"
+	"sort"
 )
 
 type filterNode struct {
 	next   *filterNode
 	filter filter.Filter
 	logger *logrus.Entry
+	name   string
+	weight float64
+	phase  filter.Phase
+
+	// fingerprint identifies the filter's configuration, so remembered rejections can be dropped when it changes
+	fingerprint string
+	volatile    bool
 }
 
-func (fn *filterNode) isValid(img background.Background) bool {
-	currentValid := fn.filter.IsValid(img)
-	if !currentValid {
-		fn.logger.Debug("filter rejected")
-		return false
+// resultRecorder is notified of every filter's verdict
+type resultRecorder func(filterName string, accepted bool)
+
+// isValid runs the chain against the background, returning the node that rejected it along with the rejection
+func (fn *filterNode) isValid(img background.Background, record resultRecorder) (*filterNode, *filter.Rejection) {
+	// the chain is ordered by phase, so this is the first point the image is needed
+	if fn.phase == filter.PhasePixels && !img.IsLoaded() {
+		if err := img.Load(); err != nil {
+			return fn, filter.RejectTransient("unable to load image: %s", err.Error())
+		}
+	}
+
+	rejection := fn.filter.IsValid(img)
+	record(fn.name, rejection == nil)
+	if rejection != nil {
+		fn.logger.Debugf("filter rejected: %s", rejection)
+		return fn, rejection
 	} else {
 		fn.logger.Debug("filter approved")
 	}
 
-	return fn.next == nil || fn.next.isValid(img)
+	if fn.next == nil {
+		return nil, nil
+	}
+
+	return fn.next.isValid(img, record)
+}
+
+// recheck runs the chain against a background that was accepted before, without recording results
+// volatile filters are skipped, their verdict was settled when the background was accepted
+func (fn *filterNode) recheck(img background.Background) (*filterNode, *filter.Rejection) {
+	for current := fn; current != nil; current = current.next {
+		if current.volatile {
+			continue
+		}
+
+		if current.phase == filter.PhasePixels && !img.IsLoaded() {
+			if err := img.Load(); err != nil {
+				return current, filter.RejectTransient("unable to load image: %s", err.Error())
+			}
+		}
+
+		if rejection := current.filter.IsValid(img); rejection != nil {
+			return current, rejection
+		}
+	}
+
+	return nil, nil
 }
 
 func (fn *filterNode) copy() *filterNode {
@@ -34,7 +83,58 @@ func (fn *filterNode) copy() *filterNode {
 		next:   next,
 		filter: fn.filter,
 		logger: fn.logger,
+		name:   fn.name,
+		weight: fn.weight,
+		phase:  fn.phase,
+
+		fingerprint: fn.fingerprint,
+		volatile:    fn.volatile,
+	}
+}
+
+// fingerprints returns the set of fingerprints of every filter in the chain
+func (fn *filterNode) fingerprints() map[string]bool {
+	fingerprints := map[string]bool{}
+	for current := fn; current != nil; current = current.next {
+		fingerprints[current.fingerprint] = true
 	}
+
+	return fingerprints
+}
+
+// score computes the weighted mean score of every scoring filter in the chain, along with
+// each filter's individual score. a chain without scoring filters scores 0
+func (fn *filterNode) score(img background.Background) (float64, map[string]float64) {
+	breakdown := map[string]float64{}
+	total := 0.0
+	totalWeight := 0.0
+	for current := fn; current != nil; current = current.next {
+		scorer, ok := current.filter.(filter.Scorer)
+		if !ok {
+			continue
+		}
+
+		score := math.Max(0, math.Min(1, scorer.Score(img)))
+		current.logger.Debugf("filter scored %2f", score)
+
+		name := current.name
+		for i := 2; ; i++ {
+			if _, exists := breakdown[name]; !exists {
+				break
+			}
+			name = fmt.Sprintf("%s-%d", current.name, i)
+		}
+		breakdown[name] = score
+
+		total += score * current.weight
+		totalWeight += current.weight
+	}
+
+	if totalWeight == 0 {
+		return 0, breakdown
+	}
+
+	return total / totalWeight, breakdown
 }
 
 func (fn *filterNode) getLastNode() *filterNode {
@@ -44,3 +144,51 @@ func (fn *filterNode) getLastNode() *filterNode {
 
 	return fn
 }
+
+// orderByPhase reorders the chain so that cheaper phases run first,
+// keeping the configured order within each phase
+func (fn *filterNode) orderByPhase() *filterNode {
+	var ordered []*filterNode
+	for current := fn; current != nil; current = current.next {
+		ordered = append(ordered, current)
+	}
+
+	if len(ordered) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].phase < ordered[j].phase
+	})
+
+	for i, current := range ordered {
+		current.next = nil
+		if i+1 < len(ordered) {
+			current.next = ordered[i+1]
+		}
+	}
+
+	return ordered[0]
+}
+
+// chainFingerprint combines the fingerprint of every filter in the chain, in order
+func (fn *filterNode) chainFingerprint() string {
+	hash := sha1.New()
+	for current := fn; current != nil; current = current.next {
+		_, _ = fmt.Fprintln(hash, current.fingerprint)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)[:8])
+}
+
+// fingerprintFilter hashes a filter's type and options, along with any inputs the filter reports itself
+// fmt prints maps with sorted keys, so equal configurations always hash the same
+func fingerprintFilter(conf *filter.Configuration, impl filter.Filter) string {
+	described := fmt.Sprintf("%s %v", conf.Type, conf.Options)
+	if fingerprinter, ok := impl.(filter.Fingerprinter); ok {
+		described = fmt.Sprintf("%s %s", described, fingerprinter.Fingerprint())
+	}
+
+	sum := sha1.Sum([]byte(described))
+	return fmt.Sprintf("%x", sum[:8])
+}
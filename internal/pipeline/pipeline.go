@@ -5,13 +5,35 @@
 	"bgfreshd/internal/db"
 	"bgfreshd/pkg/background"
 	"bgfreshd/pkg/filter"
+	"fmt"
 	"github.com/mroth/weightedrand"
 	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
 )
 
+// FilterFingerprintKey is the metadata key holding the fingerprint of the filters a background was accepted by
+const FilterFingerprintKey = "filter-fingerprint"
+
 // Pipeline is the encapsulation of all of the loading and filtering logic
 type Pipeline interface {
 	LoadOne() (background.Background, error)
+
+	// Reevaluate runs the current filters of a background's source against a background accepted earlier
+	Reevaluate(bg background.Background) *Reevaluation
+}
+
+// Reevaluation is the outcome of running the current filters against a background accepted earlier
+type Reevaluation struct {
+	// Unchanged is set when the filters match the ones the background was accepted by, so none ran
+	Unchanged bool
+
+	// Fingerprint of the current filters
+	Fingerprint string
+
+	// Filter that rejected the background, empty when it still passes
+	Filter    string
+	Rejection *filter.Rejection
 }
 
 // NewPipeline creates the pipeline from config
@@ -28,7 +50,7 @@ func NewPipeline(config *config.Config, db db.BackgroundDb, log *logrus.Logger)
 
 	pipelineLog.Info("Building image gathering pipeline")
 
-	globalFilter, err := loadFilters(config.Filters, filterLog)
+	globalFilter, err := loadFilters(globalFilterConfigs(config), filterLog)
 	if err != nil {
 		return nil, err
 	}
@@ -41,9 +63,11 @@ func NewPipeline(config *config.Config, db db.BackgroundDb, log *logrus.Logger)
 			return nil, err
 		}
 
-		globalCopy := globalFilter.copy()
-		last := globalCopy.getLastNode()
-		last.next = sourceFilter
+		filters := sourceFilter
+		if globalFilter != nil {
+			filters = globalFilter.copy()
+			filters.getLastNode().next = sourceFilter
+		}
 
 		loadedSource, err := CreateSource(&currentSource, db, sourceLog)
 		if err != nil {
@@ -57,9 +81,15 @@ func NewPipeline(config *config.Config, db db.BackgroundDb, log *logrus.Logger)
 
 		node := sourceNode{
 			source:  loadedSource,
-			filters: globalCopy,
+			filters: filters.orderByPhase(),
 			weight:  weight,
+			db:      db,
+			logger: pipelineLog.WithFields(logrus.Fields{
+				"source": loadedSource.GetName(),
+			}),
 		}
+		node.fingerprint = node.filters.chainFingerprint()
+		node.pruneRejections()
 		sources = append(sources, node)
 	}
 
@@ -75,11 +105,36 @@ func NewPipeline(config *config.Config, db db.BackgroundDb, log *logrus.Logger)
 	return &pipeline{
 		sourceChooser: sourceChooser,
 		sources:       sources,
+		globalFilters: globalFilter.orderByPhase(),
+		tournament:    config.Tournament,
 		pipelineLog:   pipelineLog,
 		mainLog:       log,
 	}, nil
 }
 
+// globalFilterConfigs adds the filters implied by the output settings to the configured global filters
+func globalFilterConfigs(cfg *config.Config) []filter.Configuration {
+	configured := append([]filter.Configuration{}, cfg.Filters...)
+	if cfg.Output == nil || cfg.Output.MaxUpscale == 0 {
+		return configured
+	}
+
+	width, height := cfg.Output.Size()
+	if width == 0 {
+		return configured
+	}
+
+	return append(configured, filter.Configuration{
+		Type: "upscale",
+		Name: "output",
+		Options: map[string]interface{}{
+			"width":      width,
+			"height":     height,
+			"maxUpscale": cfg.Output.MaxUpscale,
+		},
+	})
+}
+
 func loadFilters(configured []filter.Configuration, filterLog *logrus.Entry) (*filterNode, error) {
 	if len(configured) == 0 {
 		return nil, nil
@@ -114,23 +169,45 @@ func loadSingleFilter(conf *filter.Configuration, filterLog *logrus.Entry) (*fil
 		return nil, err
 	}
 
+	weight := 1.0
+	if conf.Weight != nil {
+		weight = *conf.Weight
+	}
+
+	name := conf.Name
+	if len(name) == 0 {
+		name = conf.Type
+	}
+
 	return &filterNode{
 		next:   nil,
 		filter: filterImpl,
 		logger: filterLog.WithFields(logrus.Fields{
-			"filter": conf.Type,
+			"filter": name,
 		}),
+		name:   name,
+		weight: weight,
+		phase:  filter.PhaseOf(filterImpl),
+
+		fingerprint: fingerprintFilter(conf, filterImpl),
+		volatile:    filter.IsVolatile(filterImpl),
 	}, nil
 }
 
 type pipeline struct {
 	sourceChooser weightedrand.Chooser
 	sources       []sourceNode
+	globalFilters *filterNode
+	tournament    *config.TournamentConfig
 	pipelineLog   *logrus.Entry
 	mainLog       *logrus.Logger
 }
 
 func (p pipeline) LoadOne() (background.Background, error) {
+	if p.tournament != nil {
+		return p.loadBestOf(p.tournament.Candidates)
+	}
+
 	attempts := 0
 	p.pipelineLog.Info("Loading image")
 	for attempts < 10 {
@@ -152,6 +229,77 @@ func (p pipeline) LoadOne() (background.Background, error) {
 	return nil, &ExceededRetriesError{}
 }
 
+// loadBestOf gathers valid candidates across sources, keeping the highest scoring one
+func (p pipeline) loadBestOf(candidates int) (background.Background, error) {
+	var best background.Background
+	bestScore := -1.0
+	gathered := 0
+	attempts := 0
+	p.pipelineLog.Infof("Loading best of %d images", candidates)
+	for gathered < candidates && attempts < 10+candidates {
+		attempts++
+
+		source := p.sourceChooser.Pick().(sourceNode)
+		bg, err := source.next()
+		if err != nil {
+			p.pipelineLog.Warnf("error in pipeline process: %s, retrying", err.Error())
+			continue
+		}
+		gathered++
+
+		score, breakdown := source.filters.score(bg)
+		bg.AddMetadata("score", fmt.Sprintf("%.3f", score))
+		details := make([]string, 0, len(breakdown))
+		for name, filterScore := range breakdown {
+			bg.AddMetadata(fmt.Sprintf("score-%s", name), fmt.Sprintf("%.3f", filterScore))
+			details = append(details, fmt.Sprintf("%s: %.3f", name, filterScore))
+		}
+		sort.Strings(details)
+		p.pipelineLog.Infof("candidate %s scored %.3f (%s)", bg.GetName(), score, strings.Join(details, ", "))
+
+		if score > bestScore {
+			best = bg
+			bestScore = score
+		}
+	}
+
+	if best == nil {
+		p.pipelineLog.Error("Pipeline exceeded max retries")
+		return nil, &ExceededRetriesError{}
+	}
+
+	p.pipelineLog.Infof("candidate %s won with score %.3f out of %d candidates", best.GetName(), bestScore, gathered)
+	return best, nil
+}
+
+func (p pipeline) Reevaluate(bg background.Background) *Reevaluation {
+	// backgrounds of sources that have since been removed only answer to the global filters
+	filters := p.globalFilters
+	for _, s := range p.sources {
+		if s.source.GetName() == bg.GetMetadata("source-name") {
+			filters = s.filters
+			break
+		}
+	}
+
+	result := &Reevaluation{
+		Fingerprint: filters.chainFingerprint(),
+	}
+
+	if result.Fingerprint == bg.GetMetadata(FilterFingerprintKey) {
+		result.Unchanged = true
+		return result
+	}
+
+	rejectedBy, rejection := filters.recheck(bg)
+	if rejection != nil {
+		result.Filter = rejectedBy.name
+		result.Rejection = rejection
+	}
+
+	return result
+}
+
 type ExceededRetriesError struct {
 }
 
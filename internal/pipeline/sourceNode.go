@@ -1,15 +1,25 @@
 package pipeline
 
 import (
+	"bgfreshd/internal/db"
 	"bgfreshd/pkg"
 	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/filter"
 	"bgfreshd/pkg/source"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
 )
 
 type sourceNode struct {
 	source  source.Source
 	filters *filterNode
 	weight  uint
+	db      db.BackgroundDb
+	logger  *logrus.Entry
+
+	// fingerprint identifies the whole filter chain, accepted backgrounds carry it in their metadata
+	fingerprint string
 }
 
 func (sn *sourceNode) next() (background.Background, error) {
@@ -23,11 +33,102 @@ func (sn *sourceNode) next() (background.Background, error) {
 			return nil, &pkg.SourceEmptyError{Source: sn.source}
 		}
 
-		found := sn.filters.isValid(current)
-		if found {
-			return current, nil
+		keys := candidateKeys(current)
+		if record := sn.knownRejection(keys); record != nil {
+			sn.logger.Debugf("skipping %s, rejected before by %s: %s", current.GetName(), record.Filter, record.Reason)
+			continue
+		}
+
+		var rejectedBy *filterNode
+		var rejection *filter.Rejection
+		if sn.filters != nil {
+			var results []db.FilterResult
+			rejectedBy, rejection = sn.filters.isValid(current, func(filterName string, accepted bool) {
+				results = append(results, db.FilterResult{Filter: filterName, Accepted: accepted})
+			})
+			sn.recordResults(results)
+		}
+
+		if rejection != nil {
+			sn.logger.Infof("rejected %s: %s", current.GetName(), rejection)
+			sn.rememberRejection(keys, rejectedBy, rejection)
+			continue
 		}
+
+		// every filter may have run before the image was needed
+		if err := current.Load(); err != nil {
+			sn.logger.Warnf("error loading %s: %s", current.GetName(), err.Error())
+			continue
+		}
+
+		current.AddMetadata(FilterFingerprintKey, sn.fingerprint)
+		return current, nil
 	}
 
 	return nil, &pkg.SourceEmptyError{Source: sn.source}
 }
+
+// recordResults counts a candidate's verdicts together, keeping it to one write however many filters ran
+func (sn *sourceNode) recordResults(results []db.FilterResult) {
+	if err := sn.db.RecordFilterResults(sn.source.GetName(), results); err != nil {
+		sn.logger.Warnf("error recording filter result: %s", err.Error())
+	}
+}
+
+// candidateKeys lists every key a candidate is remembered by, the url catches reposts under a new name
+func candidateKeys(bg background.Background) []string {
+	keys := []string{fmt.Sprintf("name:%s", bg.GetName())}
+	if url := bg.GetMetadata("url"); len(url) != 0 {
+		keys = append(keys, fmt.Sprintf("url:%s", url))
+	}
+
+	return keys
+}
+
+// knownRejection returns the remembered rejection of a candidate if the filter that made it is unchanged
+func (sn *sourceNode) knownRejection(keys []string) *db.RejectionRecord {
+	if sn.filters == nil {
+		return nil
+	}
+
+	record, err := sn.db.GetRejection(sn.source.GetName(), keys)
+	if err != nil {
+		sn.logger.Warnf("error reading remembered rejection: %s", err.Error())
+		return nil
+	}
+
+	if record == nil || !sn.filters.fingerprints()[record.Fingerprint] {
+		return nil
+	}
+
+	return record
+}
+
+func (sn *sourceNode) rememberRejection(keys []string, rejectedBy *filterNode, rejection *filter.Rejection) {
+	if rejection.Transient || rejectedBy.volatile {
+		return
+	}
+
+	err := sn.db.RecordRejection(sn.source.GetName(), keys, db.RejectionRecord{
+		Filter:      rejectedBy.name,
+		Fingerprint: rejectedBy.fingerprint,
+		Reason:      rejection.Reason,
+		RejectedOn:  time.Now(),
+	})
+	if err != nil {
+		sn.logger.Warnf("error remembering rejection: %s", err.Error())
+	}
+}
+
+// pruneRejections forgets the rejections made by filters whose configuration has since changed
+func (sn *sourceNode) pruneRejections() {
+	pruned, err := sn.db.PruneRejections(sn.source.GetName(), sn.filters.fingerprints())
+	if err != nil {
+		sn.logger.Warnf("error pruning remembered rejections: %s", err.Error())
+		return
+	}
+
+	if pruned != 0 {
+		sn.logger.Infof("forgot %d remembered rejection entries after the filter configuration changed", pruned)
+	}
+}
@@ -0,0 +1,99 @@
+package sinks
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/sink"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+)
+
+type LocalOptions struct {
+	// Path is the directory files are mirrored to
+	Path string `yaml:"path"`
+}
+
+func init() {
+	pipeline.AddSinkRegistration("local", NewLocalSink)
+}
+
+func NewLocalSink(config *sink.Configuration, sinkLog *logrus.Entry) (sink.OutputSink, error) {
+	var options LocalOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	if len(options.Path) == 0 {
+		return nil, errors.New("local sink requires a path")
+	}
+
+	if err := os.MkdirAll(options.Path, 0755); err != nil {
+		return nil, err
+	}
+
+	return &localSink{
+		log:  sinkLog,
+		name: sinkName(config),
+		opts: &options,
+	}, nil
+}
+
+type localSink struct {
+	log  *logrus.Entry
+	name string
+	opts *LocalOptions
+}
+
+func (l *localSink) Put(name string, data []byte) error {
+	filename := filepath.Join(l.opts.Path, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	return internal.WriteFileAtomic(filename, data, 0644)
+}
+
+func (l *localSink) Remove(name string) error {
+	err := os.Remove(filepath.Join(l.opts.Path, filepath.FromSlash(name)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (l *localSink) List() ([]string, error) {
+	var names []string
+	err := filepath.Walk(l.opts.Path, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.opts.Path, filename)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+
+	return names, err
+}
+
+func (l *localSink) GetName() string {
+	return l.name
+}
+
+// sinkName is the configured name of a sink, defaulting to its type
+func sinkName(config *sink.Configuration) string {
+	if len(config.Name) != 0 {
+		return config.Name
+	}
+
+	return config.Type
+}
@@ -0,0 +1,69 @@
+package sinks
+
+import (
+	"bgfreshd/pkg/sink"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLocalSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bgfreshd-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewLocalSink(&sink.Configuration{Type: "local", Options: map[string]interface{}{"path": dir}}, testLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put("nested/bg.jpeg", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("nested/bg.jpeg", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("other.png", []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "nested", "bg.jpeg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("expected the file to be replaced, got %q", data)
+	}
+
+	names, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "nested/bg.jpeg,other.png" {
+		t.Errorf("unexpected listing %v", names)
+	}
+
+	if err := s.Remove("other.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove("other.png"); err != nil {
+		t.Errorf("removing a missing file should succeed, got %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.png")); !os.IsNotExist(err) {
+		t.Error("expected the file to be removed")
+	}
+}
+
+func testLog() *logrus.Entry {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	return logrus.NewEntry(log)
+}
@@ -0,0 +1,346 @@
+package sinks
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/sink"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// listBucketResult is the part of a ListObjectsV2 response listing needs
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+type S3Options struct {
+	// Endpoint of the S3 compatible service, defaulting to https://s3.<region>.amazonaws.com
+	Endpoint string `yaml:"endpoint"`
+
+	// Region signed into requests, defaulting to us-east-1
+	Region string `yaml:"region"`
+
+	Bucket string `yaml:"bucket"`
+
+	// Prefix is prepended to every key, IE backgrounds/
+	Prefix string `yaml:"prefix"`
+
+	AccessKey string `yaml:"accessKey"`
+	SecretKey string `yaml:"secretKey"`
+
+	// PathStyle addresses the bucket as part of the path instead of the host, which most self hosted services need
+	PathStyle bool `yaml:"pathStyle"`
+
+	// Timeout of each request in seconds, defaulting to 60
+	Timeout int `yaml:"timeout"`
+}
+
+func init() {
+	pipeline.AddSinkRegistration("s3", NewS3Sink)
+}
+
+func NewS3Sink(config *sink.Configuration, sinkLog *logrus.Entry) (sink.OutputSink, error) {
+	var options S3Options
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	if len(options.Bucket) == 0 || len(options.AccessKey) == 0 || len(options.SecretKey) == 0 {
+		return nil, errors.New("s3 sink requires a bucket, accessKey and secretKey")
+	}
+
+	// default vals if unset
+	if len(options.Region) == 0 {
+		options.Region = "us-east-1"
+	}
+	if len(options.Endpoint) == 0 {
+		options.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", options.Region)
+	}
+	if options.Timeout == 0 {
+		options.Timeout = 60
+	}
+
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, errors.New("s3 sink requires an http or https endpoint")
+	}
+
+	return &s3Sink{
+		log:      sinkLog,
+		name:     sinkName(config),
+		opts:     &options,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: time.Duration(options.Timeout) * time.Second},
+		now:      time.Now,
+	}, nil
+}
+
+type s3Sink struct {
+	log      *logrus.Entry
+	name     string
+	opts     *S3Options
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// Put uploads the object in a single request, which S3 only makes visible once it's complete
+func (s *s3Sink) Put(name string, data []byte) error {
+	header := http.Header{}
+	if contentType := mime.TypeByExtension(path.Ext(name)); len(contentType) != 0 {
+		header.Set("Content-Type", contentType)
+	}
+
+	status, body, err := s.do(http.MethodPut, name, header, data)
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("s3 put of %s failed with status %d: %s", name, status, body)
+	}
+
+	return nil
+}
+
+func (s *s3Sink) Remove(name string) error {
+	status, body, err := s.do(http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusNotFound && (status < 200 || status > 299) {
+		return fmt.Errorf("s3 delete of %s failed with status %d: %s", name, status, body)
+	}
+
+	return nil
+}
+
+// List pages through the keys under the prefix with ListObjectsV2
+func (s *s3Sink) List() ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.opts.Prefix)
+		if len(token) != 0 {
+			query.Set("continuation-token", token)
+		}
+
+		status, body, err := s.send(http.MethodGet, s.bucketUrl(query), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if status < 200 || status > 299 {
+			return nil, fmt.Errorf("s3 list failed with status %d: %s", status, shorten(body))
+		}
+
+		var result listBucketResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, s.opts.Prefix))
+		}
+
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Sink) GetName() string {
+	return s.name
+}
+
+// bucketUrl addresses the bucket itself, as listing does
+func (s *s3Sink) bucketUrl(query url.Values) *url.URL {
+	u := *s.endpoint
+	bucketPath := "/"
+	if s.opts.PathStyle {
+		bucketPath = "/" + s.opts.Bucket + "/"
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+	}
+
+	u.Path = strings.TrimSuffix(s.endpoint.Path, "/") + bucketPath
+	u.RawPath = ""
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+func (s *s3Sink) objectUrl(name string) *url.URL {
+	u := *s.endpoint
+	key := s.opts.Prefix + name
+	objectPath := "/" + key
+	if s.opts.PathStyle {
+		objectPath = "/" + s.opts.Bucket + objectPath
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+	}
+
+	u.Path = strings.TrimSuffix(s.endpoint.Path, "/") + objectPath
+	u.RawPath = strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + uriEncode(objectPath, false)
+	return &u
+}
+
+func (s *s3Sink) do(method string, name string, header http.Header, body []byte) (int, string, error) {
+	status, respBody, err := s.send(method, s.objectUrl(name), header, body)
+	return status, shorten(respBody), err
+}
+
+func (s *s3Sink) send(method string, u *url.URL, header http.Header, body []byte) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, u.String(), reader)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	signV4(req, body, s.opts.AccessKey, s.opts.SecretKey, s.opts.Region, "s3", s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer internal.Deferrer(s.log, resp.Body.Close)
+
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, respBody, nil
+}
+
+// shorten keeps the xml bodies errors come with short for logs
+func shorten(body []byte) string {
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+
+	return strings.TrimSpace(string(body))
+}
+
+// signV4 signs the request with AWS signature version 4, covering the host and every header already set
+func signV4(req *http.Request, body []byte, accessKey string, secretKey string, region string, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for key := range headers {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, key := range names {
+		canonicalHeaders.WriteString(key + ":" + headers[key] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+secretKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, service)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent encodes everything but the unreserved characters as signing expects, slashes are
+// left alone in paths
+func uriEncode(val string, encodeSlash bool) string {
+	var buf strings.Builder
+	for _, b := range []byte(val) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			buf.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+
+	return buf.String()
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,285 @@
+package sinks
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/sink"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxResponseSize bounds how much of a response is read, listings of a large collection included
+const maxResponseSize = 16 << 20
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`
+
+// multistatus is the part of a PROPFIND response listing needs
+type multistatus struct {
+	Responses []multistatusResponse `xml:"DAV: response"`
+}
+
+type multistatusResponse struct {
+	Href     string `xml:"DAV: href"`
+	Propstat []struct {
+		Collection *struct{} `xml:"DAV: prop>resourcetype>collection"`
+	} `xml:"DAV: propstat"`
+}
+
+func (r *multistatusResponse) isCollection() bool {
+	for _, propstat := range r.Propstat {
+		if propstat.Collection != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+type WebdavOptions struct {
+	// Url of the collection files are mirrored to
+	Url string `yaml:"url"`
+
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// Timeout of each request in seconds, defaulting to 60
+	Timeout int `yaml:"timeout"`
+}
+
+func init() {
+	pipeline.AddSinkRegistration("webdav", NewWebdavSink)
+}
+
+func NewWebdavSink(config *sink.Configuration, sinkLog *logrus.Entry) (sink.OutputSink, error) {
+	var options WebdavOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(options.Url)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, errors.New("webdav sink requires an http or https url")
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+
+	// default val if unset
+	if options.Timeout == 0 {
+		options.Timeout = 60
+	}
+
+	return &webdavSink{
+		log:    sinkLog,
+		name:   sinkName(config),
+		opts:   &options,
+		base:   base,
+		client: &http.Client{Timeout: time.Duration(options.Timeout) * time.Second},
+	}, nil
+}
+
+type webdavSink struct {
+	log    *logrus.Entry
+	name   string
+	opts   *WebdavOptions
+	base   *url.URL
+	client *http.Client
+}
+
+// Put uploads to a temporary name and moves it into place, so clients of the share never see a partial file
+func (w *webdavSink) Put(name string, data []byte) error {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	dir, base := path.Split(name)
+	tmp := path.Join(dir, fmt.Sprintf("%s%s.%s", internal.TempFilePrefix, base, hex.EncodeToString(suffix)))
+
+	header := http.Header{}
+	if contentType := mime.TypeByExtension(path.Ext(name)); len(contentType) != 0 {
+		header.Set("Content-Type", contentType)
+	}
+
+	status, _, err := w.do(http.MethodPut, tmp, header, data)
+	if err == nil && status == http.StatusConflict {
+		// the parent collections don't exist yet
+		if err := w.makeCollections(dir); err != nil {
+			return err
+		}
+		status, _, err = w.do(http.MethodPut, tmp, header, data)
+	}
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("webdav put of %s failed with status %d", name, status)
+	}
+
+	moveHeader := http.Header{}
+	moveHeader.Set("Destination", w.url(name))
+	moveHeader.Set("Overwrite", "T")
+	status, _, err = w.do("MOVE", tmp, moveHeader, nil)
+	if err == nil && (status < 200 || status > 299) {
+		err = fmt.Errorf("webdav move of %s failed with status %d", name, status)
+	}
+	if err != nil {
+		if _, _, removeErr := w.do(http.MethodDelete, tmp, nil, nil); removeErr != nil {
+			w.log.Warnf("unable to remove temporary upload %s: %s", tmp, removeErr.Error())
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (w *webdavSink) Remove(name string) error {
+	status, _, err := w.do(http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusNotFound && (status < 200 || status > 299) {
+		return fmt.Errorf("webdav delete of %s failed with status %d", name, status)
+	}
+
+	return nil
+}
+
+// List walks the collections with PROPFIND, a depth of one at a time as many servers refuse infinite depth
+func (w *webdavSink) List() ([]string, error) {
+	var names []string
+	pending := []string{""}
+	for len(pending) != 0 {
+		dir := pending[0]
+		pending = pending[1:]
+
+		header := http.Header{}
+		header.Set("Depth", "1")
+		header.Set("Content-Type", "application/xml; charset=utf-8")
+		status, body, err := w.do("PROPFIND", dir, header, []byte(propfindBody))
+		if err != nil {
+			return nil, err
+		}
+		if status == http.StatusNotFound && len(dir) == 0 {
+			return nil, nil
+		}
+		if status != http.StatusMultiStatus {
+			return nil, fmt.Errorf("webdav propfind of %s failed with status %d", dir, status)
+		}
+
+		var result multistatus
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+
+		for _, response := range result.Responses {
+			name, ok := w.relativeName(response.Href)
+			if !ok || strings.TrimSuffix(name, "/") == strings.TrimSuffix(dir, "/") {
+				continue
+			}
+
+			if response.isCollection() {
+				pending = append(pending, strings.TrimSuffix(name, "/")+"/")
+			} else {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names, nil
+}
+
+func (w *webdavSink) GetName() string {
+	return w.name
+}
+
+// makeCollections creates each collection along dir, those that already exist answer with 405
+func (w *webdavSink) makeCollections(dir string) error {
+	current := ""
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if len(part) == 0 {
+			continue
+		}
+
+		current += part + "/"
+		status, _, err := w.do("MKCOL", current, nil, nil)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusMethodNotAllowed && (status < 200 || status > 299) {
+			return fmt.Errorf("webdav mkcol of %s failed with status %d", current, status)
+		}
+	}
+
+	return nil
+}
+
+// relativeName turns a href of a PROPFIND response, a path or a full url, into a name within the base collection
+func (w *webdavSink) relativeName(href string) (string, bool) {
+	parsed, err := url.Parse(href)
+	if err != nil || !strings.HasPrefix(parsed.Path, w.base.Path) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(parsed.Path, w.base.Path), true
+}
+
+func (w *webdavSink) url(name string) string {
+	u := *w.base
+	var escaped []string
+	for _, part := range strings.Split(name, "/") {
+		escaped = append(escaped, url.PathEscape(part))
+	}
+
+	u.Path = w.base.Path + name
+	u.RawPath = w.base.EscapedPath() + strings.Join(escaped, "/")
+	return u.String()
+}
+
+func (w *webdavSink) do(method string, name string, header http.Header, body []byte) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, w.url(name), reader)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if len(w.opts.Username) != 0 {
+		req.SetBasicAuth(w.opts.Username, w.opts.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer internal.Deferrer(w.log, resp.Body.Close)
+
+	// read in full so the connection can be reused
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, respBody, nil
+}
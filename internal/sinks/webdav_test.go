@@ -0,0 +1,224 @@
+package sinks
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/pkg/sink"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeWebdav is an in memory stand in for a webdav server, holding files and collections by their path
+type fakeWebdav struct {
+	lock        sync.Mutex
+	files       map[string][]byte
+	collections map[string]bool
+	requests    []string
+	overwrite   []string
+	failMove    bool
+}
+
+func newFakeWebdav() *fakeWebdav {
+	return &fakeWebdav{
+		files:       map[string][]byte{},
+		collections: map[string]bool{"/dav/": true, "/dav/backgrounds/": true},
+	}
+}
+
+func (f *fakeWebdav) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "me" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	name := r.URL.Path
+	f.requests = append(f.requests, r.Method+" "+name)
+	parent := path.Dir(strings.TrimSuffix(name, "/")) + "/"
+
+	switch r.Method {
+	case http.MethodPut:
+		if !f.collections[parent] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		f.files[name] = body
+		w.WriteHeader(http.StatusCreated)
+	case "MKCOL":
+		switch {
+		case f.collections[name]:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case !f.collections[parent]:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			f.collections[name] = true
+			w.WriteHeader(http.StatusCreated)
+		}
+	case "MOVE":
+		f.overwrite = append(f.overwrite, r.Header.Get("Overwrite"))
+		destination, err := url.Parse(r.Header.Get("Destination"))
+		if err != nil || f.failMove {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		data, ok := f.files[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.files, name)
+		f.files[destination.Path] = data
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if _, ok := f.files[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.files, name)
+		w.WriteHeader(http.StatusNoContent)
+	case "PROPFIND":
+		if r.Header.Get("Depth") != "1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if !f.collections[name] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		_, _ = fmt.Fprint(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+		f.writeResponse(w, name, true)
+		for collection := range f.collections {
+			if collection != name && path.Dir(strings.TrimSuffix(collection, "/"))+"/" == name {
+				f.writeResponse(w, collection, true)
+			}
+		}
+		for file := range f.files {
+			if path.Dir(file)+"/" == name {
+				f.writeResponse(w, file, false)
+			}
+		}
+		_, _ = fmt.Fprint(w, `</d:multistatus>`)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeWebdav) writeResponse(w http.ResponseWriter, name string, collection bool) {
+	resourceType := "<d:resourcetype/>"
+	if collection {
+		resourceType = "<d:resourcetype><d:collection/></d:resourcetype>"
+	}
+
+	href := (&url.URL{Path: name}).EscapedPath()
+	_, _ = fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop>%s</d:prop>`+
+		`<d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, href, resourceType)
+}
+
+func newTestWebdavSink(t *testing.T, server *httptest.Server) sink.OutputSink {
+	s, err := NewWebdavSink(&sink.Configuration{Type: "webdav", Options: map[string]interface{}{
+		"url":      server.URL + "/dav/backgrounds",
+		"username": "me",
+		"password": "secret",
+	}}, testLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestWebdavPutCreatesCollectionsAndMoves(t *testing.T) {
+	fake := newFakeWebdav()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s := newTestWebdavSink(t, server)
+
+	if err := s.Put("sub dir/bg one.jpeg", []byte("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.requests) != 4 {
+		t.Fatalf("expected put, mkcol, put and move, got %v", fake.requests)
+	}
+	tmp := strings.TrimPrefix(fake.requests[0], "PUT ")
+	if !strings.HasPrefix(path.Base(tmp), internal.TempFilePrefix) || path.Dir(tmp) != "/dav/backgrounds/sub dir" {
+		t.Errorf("expected an upload to a temp name next to the file, got %s", fake.requests[0])
+	}
+	expected := []string{"PUT " + tmp, "MKCOL /dav/backgrounds/sub dir/", "PUT " + tmp, "MOVE " + tmp}
+	if strings.Join(fake.requests, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %v, got %v", expected, fake.requests)
+	}
+	if len(fake.overwrite) != 1 || fake.overwrite[0] != "T" {
+		t.Errorf("expected the move to overwrite, got %v", fake.overwrite)
+	}
+
+	if string(fake.files["/dav/backgrounds/sub dir/bg one.jpeg"]) != "jpeg" {
+		t.Errorf("expected the file in place, got %v", fake.files)
+	}
+	if len(fake.files) != 1 {
+		t.Errorf("expected the temp upload to be gone, got %v", fake.files)
+	}
+}
+
+func TestWebdavPutRemovesTempOnFailedMove(t *testing.T) {
+	fake := newFakeWebdav()
+	fake.failMove = true
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s := newTestWebdavSink(t, server)
+
+	if err := s.Put("bg.jpeg", []byte("jpeg")); err == nil {
+		t.Fatal("expected a failed move to fail the put")
+	}
+
+	if len(fake.files) != 0 {
+		t.Errorf("expected the temp upload to be removed, got %v", fake.files)
+	}
+}
+
+func TestWebdavRemoveMissingSucceeds(t *testing.T) {
+	fake := newFakeWebdav()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s := newTestWebdavSink(t, server)
+
+	if err := s.Remove("missing.jpeg"); err != nil {
+		t.Errorf("expected a 404 to count as removed, got %s", err)
+	}
+	if fake.requests[0] != "DELETE /dav/backgrounds/missing.jpeg" {
+		t.Errorf("unexpected request %v", fake.requests)
+	}
+}
+
+func TestWebdavList(t *testing.T) {
+	fake := newFakeWebdav()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s := newTestWebdavSink(t, server)
+
+	for _, name := range []string{"a.jpeg", "sub dir/b c.jpeg", "sub dir/deeper/d.json"} {
+		if err := s.Put(name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "a.jpeg,sub dir/b c.jpeg,sub dir/deeper/d.json" {
+		t.Errorf("unexpected listing %v", names)
+	}
+}
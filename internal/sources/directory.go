@@ -0,0 +1,154 @@
+package sources
+
+import (
+	"bgfreshd/internal"
+	"bgfreshd/internal/pipeline"
+	"bgfreshd/pkg/background"
+	"bgfreshd/pkg/source"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type DirectoryOptions struct {
+	Path      string `yaml:"path"`
+	Recursive bool   `yaml:"recursive"`
+}
+
+func init() {
+	pipeline.AddSourceRegistration("directory", NewDirectorySource)
+}
+
+func NewDirectorySource(config *source.Configuration, dbFactory source.DbFactoryFunc, sourceLog *logrus.Entry) (source.Source, error) {
+	var options DirectoryOptions
+	if err := internal.CastDecodedYamlToType(config.Options, &options); err != nil {
+		return nil, err
+	}
+
+	if len(options.Path) == 0 {
+		return nil, errors.New("directory source requires a path")
+	}
+
+	sourceLog = sourceLog.WithFields(logrus.Fields{
+		"path": options.Path,
+	})
+
+	newSource := &directorySource{
+		log: sourceLog,
+		opt: options,
+	}
+
+	db, err := dbFactory(newSource.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	newSource.db = db
+	if exists, err := newSource.db.KeyExists("last"); exists && err == nil {
+		newSource.last, err = newSource.db.GetString("last")
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return newSource, nil
+}
+
+// directorySource walks the images of a local folder in name order, starting over after it reaches the end
+type directorySource struct {
+	log  *logrus.Entry
+	db   source.Db
+	opt  DirectoryOptions
+	last string
+}
+
+func (d *directorySource) Next() (background.Background, error) {
+	files, err := d.listImages()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	index := sort.SearchStrings(files, d.last)
+	if index < len(files) && files[index] == d.last {
+		index++
+	}
+
+	// the end of the folder empties the source for this round, the next round starts over
+	next := ""
+	if index < len(files) {
+		next = files[index]
+	}
+
+	d.last = next
+	if err := d.db.SetString("last", d.last); err != nil {
+		return nil, err
+	}
+
+	if len(next) == 0 {
+		return nil, nil
+	}
+
+	d.log.Debugf("process file %s", next)
+	bg := background.FromFetcher(func() ([]byte, error) {
+		return ioutil.ReadFile(next)
+	}, directoryBackgroundName(next))
+	bg.AddMetadata("title", strings.TrimSuffix(filepath.Base(next), filepath.Ext(next)))
+	bg.AddMetadata("path", next)
+	bg.AddMetadata("source-name", d.GetName())
+
+	// files in subfolders of a recursive source are tagged with the subfolder
+	if dir, err := filepath.Rel(d.opt.Path, filepath.Dir(next)); err == nil && dir != "." {
+		bg.AddMetadata("tag", filepath.ToSlash(dir))
+	}
+
+	return bg, nil
+}
+
+func (d *directorySource) GetName() string {
+	return fmt.Sprintf("directory-%s", filepath.Base(filepath.Clean(d.opt.Path)))
+}
+
+// listImages returns the sorted paths of every supported image in the directory
+func (d *directorySource) listImages() ([]string, error) {
+	var files []string
+	err := filepath.Walk(d.opt.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path != d.opt.Path && !d.opt.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jpg", ".jpeg", ".png", ".webp":
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	sort.Strings(files)
+	return files, err
+}
+
+// directoryBackgroundName derives a stable, filesystem safe name from the file path
+func directoryBackgroundName(path string) string {
+	sum := sha1.Sum([]byte(path))
+	return fmt.Sprintf("dir-%x", sum[:8])
+}
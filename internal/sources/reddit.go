@@ -8,9 +8,7 @@
 	"bgfreshd/pkg/source"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"image"
-	"image/jpeg"
-	"image/png"
+	"io/ioutil"
 	"net/http"
 )
 
@@ -114,21 +112,37 @@ func (r *redditSource) processPage(post redditApi.Child) background.Background {
 		return nil
 	}
 
-	img, err := r.downloadImage(post.Data.URL)
-	if err != nil {
-		r.log.Warnf("error downloading image %s for post %s : \"%s\"", post.Data.URL, post.Data.Title, err.Error())
-		return nil
+	// the image is only downloaded once a filter needs it
+	imageUrl := post.Data.URL
+	bg := background.FromFetcher(func() ([]byte, error) {
+		encoded, err := r.downloadImage(imageUrl)
+		if err != nil {
+			r.log.Warnf("error downloading image %s for post %s : \"%s\"", imageUrl, post.Data.Title, err.Error())
+		}
+		return encoded, err
+	}, post.Data.Name)
+
+	// reddit's preview lists the original image's size, which lets size filters run before downloading
+	if post.Data.Preview != nil && len(post.Data.Preview.Images) != 0 {
+		source := post.Data.Preview.Images[0].Source
+		if source.Width > 0 && source.Height > 0 {
+			bg.SetDimensions(int(source.Width), int(source.Height))
+		}
 	}
 
-	bg := background.FromImage(img, post.Data.Name)
+	bg.AddMetadata("url", imageUrl)
 	bg.AddMetadata("title", post.Data.Title)
 	bg.AddMetadata("permalink", fmt.Sprintf("https://reddit.com%s", post.Data.Permalink))
+	bg.AddMetadata("author", post.Data.Author)
 	bg.AddMetadata("source-name", r.GetName())
+	if len(post.Data.LinkFlairText) != 0 {
+		bg.AddMetadata("tag", post.Data.LinkFlairText)
+	}
 
 	return bg
 }
 
-func (r *redditSource) downloadImage(imageUrl string) (image.Image, error) {
+func (r *redditSource) downloadImage(imageUrl string) ([]byte, error) {
 	resp, err := http.Get(imageUrl)
 	if err != nil {
 		return nil, err
@@ -137,12 +151,8 @@ func (r *redditSource) downloadImage(imageUrl string) (image.Image, error) {
 
 	contentType := resp.Header.Get("content-type")
 	switch contentType {
-	case "image/jpeg":
-		return jpeg.Decode(resp.Body)
-	case "image/jpg":
-		return jpeg.Decode(resp.Body)
-	case "image/png":
-		return png.Decode(resp.Body)
+	case "image/jpeg", "image/jpg", "image/png":
+		return ioutil.ReadAll(resp.Body)
 	}
 
 	return nil, &UnsupportedImageError{ContentType: contentType}
@@ -37,15 +37,17 @@ type Child struct {
 }
 
 type ChildData struct {
-	Subreddit     string `json:"subreddit"`
-	Title         string `json:"title"`
-	Name          string `json:"name"`
-	SubredditType string `json:"subreddit_type"`
-	PostHint      string `json:"post_hint,omitempty"`
-	ID            string `json:"id"`
-	Author        string `json:"author"`
-	Permalink     string `json:"permalink"`
-	URL           string `json:"url"`
+	Subreddit     string   `json:"subreddit"`
+	Title         string   `json:"title"`
+	Name          string   `json:"name"`
+	SubredditType string   `json:"subreddit_type"`
+	PostHint      string   `json:"post_hint,omitempty"`
+	ID            string   `json:"id"`
+	Author        string   `json:"author"`
+	Permalink     string   `json:"permalink"`
+	LinkFlairText string   `json:"link_flair_text,omitempty"`
+	URL           string   `json:"url"`
+	Preview       *Preview `json:"preview,omitempty"`
 }
 
 type Preview struct {
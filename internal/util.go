@@ -3,6 +3,9 @@
 import (
 	"github.com/goccy/go-yaml"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 )
 
 // CastDecodedYamlToType makes up for go-yaml not having a similar concept to
@@ -21,3 +24,56 @@ func Deferrer(logger *logrus.Entry, fun func() error) {
 		logger.Warnf("Error in deferred func: %s", err.Error())
 	}
 }
+
+// WriteFileAtomic writes data to a temp file next to filename, syncs it and renames it into place,
+// so readers never see a partially written file and a crash leaves the old file or none at all
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(filename)
+	if len(dir) == 0 {
+		dir = "."
+	}
+
+	tmp, err := ioutil.TempFile(dir, TempFilePrefix+base+".*")
+	if err != nil {
+		return err
+	}
+
+	// cleaned up unless the rename succeeds
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	renamed = true
+
+	// the rename itself is only durable once the directory is synced
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+
+	return nil
+}
+
+// TempFilePrefix starts the names of files being written by WriteFileAtomic
+const TempFilePrefix = ".bgfreshd-"
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomicReplaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bgfreshd-util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "bg.jpeg")
+	if err := ioutil.WriteFile(filename, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(filename, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("expected the file to be replaced, got %q", data)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %o", info.Mode().Perm())
+	}
+
+	assertNoTempFiles(t, dir)
+}
+
+func TestWriteFileAtomicCleansUpOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bgfreshd-util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a directory that isn't empty can't be renamed over, so the write fails after the temp file is written
+	filename := filepath.Join(dir, "bg.jpeg")
+	if err := os.MkdirAll(filepath.Join(filename, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(filename, []byte("new"), 0644); err == nil {
+		t.Fatal("expected writing over a directory to fail")
+	}
+
+	assertNoTempFiles(t, dir)
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), TempFilePrefix) {
+			t.Errorf("temp file %s was left behind", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,669 @@
+package background
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// AnalysisSize is the max width or height of the downscaled image statistics are computed on
+const AnalysisSize = 512
+
+// edgeBins is the resolution of the edge magnitude histogram
+const edgeBins = 256
+
+// Analysis holds a downscaled copy of a background's image, shared by every filter,
+// along with statistics that are computed on first use and then cached
+type Analysis struct {
+	source image.Image
+
+	downscaleOnce sync.Once
+	small         *image.RGBA
+
+	lock    sync.Mutex
+	regions map[string]*RegionStats
+}
+
+func newAnalysis(img image.Image) *Analysis {
+	return &Analysis{
+		source:  img,
+		regions: map[string]*RegionStats{},
+	}
+}
+
+// Image returns the downscaled copy of the image
+func (a *Analysis) Image() *image.RGBA {
+	a.downscaleOnce.Do(func() {
+		a.small = downscale(a.source, AnalysisSize)
+	})
+
+	return a.small
+}
+
+// Bounds returns the bounds of the downscaled image, which regions are relative to
+func (a *Analysis) Bounds() image.Rectangle {
+	return a.Image().Bounds()
+}
+
+// Whole returns the statistics of the entire image
+func (a *Analysis) Whole() *RegionStats {
+	return a.Regions(a.Bounds())
+}
+
+// Regions returns the statistics of the union of the given rects of the downscaled image
+func (a *Analysis) Regions(rects ...image.Rectangle) *RegionStats {
+	small := a.Image()
+	key := fmt.Sprint(rects)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if stats, ok := a.regions[key]; ok {
+		return stats
+	}
+
+	clipped := make([]image.Rectangle, 0, len(rects))
+	for _, rect := range rects {
+		rect = rect.Intersect(small.Bounds())
+		if !rect.Empty() {
+			clipped = append(clipped, rect)
+		}
+	}
+
+	stats := &RegionStats{
+		img:   small,
+		rects: clipped,
+	}
+	a.regions[key] = stats
+	return stats
+}
+
+// Histogram counts the pixels of a region per 8 bit channel value
+type Histogram struct {
+	Count uint64
+	Red   [256]uint64
+	Green [256]uint64
+	Blue  [256]uint64
+	Luma  [256]uint64
+}
+
+// RegionStats lazily computes statistics over one or more rects of the downscaled image
+// each group of statistics is computed in a single parallel pass the first time it's needed
+type RegionStats struct {
+	img   *image.RGBA
+	rects []image.Rectangle
+
+	histogramOnce sync.Once
+	histogram     *Histogram
+
+	colorOnce sync.Once
+	color     colorSums
+
+	edgeOnce sync.Once
+	edge     edgeSums
+
+	binOnce sync.Once
+	bins    []colorBin
+}
+
+// DominantColor is one of the most common colors of a region
+type DominantColor struct {
+	Color color.NRGBA
+	Lab   Lab
+
+	// Share is the fraction of the region's pixels that are close to this color
+	Share float64
+}
+
+// colorBins quantizes each channel to 4 bits, which is coarse enough to group
+// similar colors while still telling apart hues a viewer would
+const colorBins = 16 * 16 * 16
+
+// dominantMergeDistance is the delta E under which neighbouring bins are treated as a single color
+const dominantMergeDistance = 12
+
+type colorBin struct {
+	count            uint64
+	red, green, blue uint64
+}
+
+type colorSums struct {
+	count         float64
+	lab           Lab
+	x, y, z       float64
+	sumRG, sumYB  float64
+	sqRG, sqYB    float64
+	saturationHSV float64
+	saturationHSL float64
+}
+
+type edgeSums struct {
+	count          float64
+	magnitudes     [edgeBins]uint64
+	laplacianCount float64
+	laplacianSum   float64
+	laplacianSq    float64
+}
+
+// Count returns the amount of pixels in the region
+func (r *RegionStats) Count() uint64 {
+	return r.Histogram().Count
+}
+
+// Histogram returns the per channel and luminance histograms of the region
+func (r *RegionStats) Histogram() *Histogram {
+	r.histogramOnce.Do(func() {
+		partials := make([]Histogram, workerCount())
+		r.eachPixel(func(worker int, c color.NRGBA) {
+			h := &partials[worker]
+			h.Count++
+			h.Red[c.R]++
+			h.Green[c.G]++
+			h.Blue[c.B]++
+			h.Luma[uint8(math.Round(Luma(c)*255))]++
+		})
+
+		r.histogram = &Histogram{}
+		for i := range partials {
+			r.histogram.Count += partials[i].Count
+			for v := 0; v < 256; v++ {
+				r.histogram.Red[v] += partials[i].Red[v]
+				r.histogram.Green[v] += partials[i].Green[v]
+				r.histogram.Blue[v] += partials[i].Blue[v]
+				r.histogram.Luma[v] += partials[i].Luma[v]
+			}
+		}
+	})
+
+	return r.histogram
+}
+
+// MeanColor returns the average color of the region
+func (r *RegionStats) MeanColor() color.NRGBA {
+	h := r.Histogram()
+	if h.Count == 0 {
+		return color.NRGBA{A: 255}
+	}
+
+	mean := func(channel *[256]uint64) uint8 {
+		total := uint64(0)
+		for v, count := range channel {
+			total += uint64(v) * count
+		}
+		return uint8(total / h.Count)
+	}
+
+	return color.NRGBA{
+		R: mean(&h.Red),
+		G: mean(&h.Green),
+		B: mean(&h.Blue),
+		A: 255,
+	}
+}
+
+// Luminance returns the mean relative luminance of the region in the range 0..1
+func (r *RegionStats) Luminance() float64 {
+	h := r.Histogram()
+	if h.Count == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for v, count := range h.Luma {
+		total += float64(v) * float64(count)
+	}
+
+	return total / float64(h.Count) / 255
+}
+
+// Entropy returns the shannon entropy of the luminance histogram normalized to the range 0..1
+func (r *RegionStats) Entropy() float64 {
+	h := r.Histogram()
+	if h.Count == 0 {
+		return 0
+	}
+
+	entropy := 0.0
+	for _, count := range h.Luma {
+		if count == 0 {
+			continue
+		}
+
+		p := float64(count) / float64(h.Count)
+		entropy -= p * math.Log2(p)
+	}
+
+	// 8 bits is the entropy of a uniformly distributed histogram
+	return entropy / 8
+}
+
+// MeanLab returns the average color of the region in CIE L*a*b*
+func (r *RegionStats) MeanLab() Lab {
+	sums := r.colorSums()
+	if sums.count == 0 {
+		return Lab{}
+	}
+
+	return Lab{
+		L: sums.lab.L / sums.count,
+		A: sums.lab.A / sums.count,
+		B: sums.lab.B / sums.count,
+	}
+}
+
+// Chromaticity returns the CIE 1931 xy chromaticity of the region's average light
+// a black region has no chromaticity and reports the D65 white point
+func (r *RegionStats) Chromaticity() (float64, float64) {
+	sums := r.colorSums()
+	total := sums.x + sums.y + sums.z
+	if total == 0 {
+		return whiteD65X, whiteD65Y
+	}
+
+	return sums.x / total, sums.y / total
+}
+
+// Colorfulness returns the Hasler-Süsstrunk colorfulness metric of the region
+// values range from 0 for grayscale images to roughly 110 for extremely colorful images
+func (r *RegionStats) Colorfulness() float64 {
+	sums := r.colorSums()
+	if sums.count == 0 {
+		return 0
+	}
+
+	meanRG := sums.sumRG / sums.count
+	meanYB := sums.sumYB / sums.count
+	varRG := sums.sqRG/sums.count - meanRG*meanRG
+	varYB := sums.sqYB/sums.count - meanYB*meanYB
+
+	return math.Sqrt(math.Max(varRG, 0)+math.Max(varYB, 0)) + 0.3*math.Sqrt(meanRG*meanRG+meanYB*meanYB)
+}
+
+// Saturation returns the mean HSV saturation of the region, or HSL saturation when hsl is set
+func (r *RegionStats) Saturation(hsl bool) float64 {
+	sums := r.colorSums()
+	if sums.count == 0 {
+		return 0
+	}
+
+	if hsl {
+		return sums.saturationHSL / sums.count
+	}
+
+	return sums.saturationHSV / sums.count
+}
+
+// EdgeDensity returns the fraction of pixels whose sobel gradient magnitude exceeds threshold,
+// threshold being a fraction of the max possible magnitude
+func (r *RegionStats) EdgeDensity(threshold float64) float64 {
+	sums := r.edgeSums()
+	if sums.count == 0 {
+		return 0
+	}
+
+	firstBin := int(math.Floor(threshold*edgeBins)) + 1
+	edges := uint64(0)
+	for bin := firstBin; bin < edgeBins; bin++ {
+		edges += sums.magnitudes[bin]
+	}
+
+	return float64(edges) / sums.count
+}
+
+// Sharpness returns the variance of the laplacian of the luminance, blurry images produce values close to 0
+func (r *RegionStats) Sharpness() float64 {
+	sums := r.edgeSums()
+	if sums.laplacianCount == 0 {
+		return 0
+	}
+
+	mean := sums.laplacianSum / sums.laplacianCount
+	return sums.laplacianSq/sums.laplacianCount - mean*mean
+}
+
+// DominantColors returns up to max of the region's most common colors, most common first
+func (r *RegionStats) DominantColors(max int) []DominantColor {
+	r.binOnce.Do(func() {
+		partials := make([][]colorBin, workerCount())
+		for i := range partials {
+			partials[i] = make([]colorBin, colorBins)
+		}
+
+		r.eachPixel(func(worker int, c color.NRGBA) {
+			bin := &partials[worker][int(c.R>>4)<<8|int(c.G>>4)<<4|int(c.B>>4)]
+			bin.count++
+			bin.red += uint64(c.R)
+			bin.green += uint64(c.G)
+			bin.blue += uint64(c.B)
+		})
+
+		r.bins = make([]colorBin, colorBins)
+		for _, partial := range partials {
+			for i := range partial {
+				r.bins[i].count += partial[i].count
+				r.bins[i].red += partial[i].red
+				r.bins[i].green += partial[i].green
+				r.bins[i].blue += partial[i].blue
+			}
+		}
+	})
+
+	type cluster struct {
+		lab   Lab
+		count uint64
+		red   uint64
+		green uint64
+		blue  uint64
+	}
+
+	bins := make([]colorBin, 0, colorBins)
+	total := uint64(0)
+	for _, bin := range r.bins {
+		if bin.count != 0 {
+			bins = append(bins, bin)
+			total += bin.count
+		}
+	}
+	sort.Slice(bins, func(i, j int) bool {
+		return bins[i].count > bins[j].count
+	})
+
+	// greedily fold each bin into the most common similar color seen so far
+	var clusters []*cluster
+	for _, bin := range bins {
+		mean := color.NRGBA{
+			R: uint8(bin.red / bin.count),
+			G: uint8(bin.green / bin.count),
+			B: uint8(bin.blue / bin.count),
+			A: 255,
+		}
+		lab := LabFromColor(mean)
+
+		var target *cluster
+		for _, c := range clusters {
+			if c.lab.Distance(lab) < dominantMergeDistance {
+				target = c
+				break
+			}
+		}
+
+		if target == nil {
+			target = &cluster{lab: lab}
+			clusters = append(clusters, target)
+		}
+		target.count += bin.count
+		target.red += bin.red
+		target.green += bin.green
+		target.blue += bin.blue
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].count > clusters[j].count
+	})
+
+	if len(clusters) > max {
+		clusters = clusters[:max]
+	}
+
+	dominant := make([]DominantColor, 0, len(clusters))
+	for _, c := range clusters {
+		mean := color.NRGBA{
+			R: uint8(c.red / c.count),
+			G: uint8(c.green / c.count),
+			B: uint8(c.blue / c.count),
+			A: 255,
+		}
+		dominant = append(dominant, DominantColor{
+			Color: mean,
+			Lab:   LabFromColor(mean),
+			Share: float64(c.count) / float64(total),
+		})
+	}
+
+	return dominant
+}
+
+func (r *RegionStats) colorSums() *colorSums {
+	r.colorOnce.Do(func() {
+		partials := make([]colorSums, workerCount())
+		r.eachPixel(func(worker int, c color.NRGBA) {
+			sums := &partials[worker]
+			lab := LabFromColor(c)
+			x, y, z := XYZ(c)
+			rg := float64(c.R) - float64(c.G)
+			yb := 0.5*(float64(c.R)+float64(c.G)) - float64(c.B)
+
+			sums.count++
+			sums.lab.L += lab.L
+			sums.lab.A += lab.A
+			sums.lab.B += lab.B
+			sums.x += x
+			sums.y += y
+			sums.z += z
+			sums.sumRG += rg
+			sums.sumYB += yb
+			sums.sqRG += rg * rg
+			sums.sqYB += yb * yb
+			sums.saturationHSV += Saturation(c, false)
+			sums.saturationHSL += Saturation(c, true)
+		})
+
+		for _, partial := range partials {
+			r.color.count += partial.count
+			r.color.lab.L += partial.lab.L
+			r.color.lab.A += partial.lab.A
+			r.color.lab.B += partial.lab.B
+			r.color.x += partial.x
+			r.color.y += partial.y
+			r.color.z += partial.z
+			r.color.sumRG += partial.sumRG
+			r.color.sumYB += partial.sumYB
+			r.color.sqRG += partial.sqRG
+			r.color.sqYB += partial.sqYB
+			r.color.saturationHSV += partial.saturationHSV
+			r.color.saturationHSL += partial.saturationHSL
+		}
+	})
+
+	return &r.color
+}
+
+func (r *RegionStats) edgeSums() *edgeSums {
+	r.edgeOnce.Do(func() {
+		// the largest possible sobel magnitude on a 0..1 image is 4*sqrt(2)
+		maxMagnitude := 4 * math.Sqrt2
+		partials := make([]edgeSums, workerCount())
+		r.eachRow(func(worker int, rect image.Rectangle, y int) {
+			if y == rect.Min.Y || y == rect.Max.Y-1 {
+				return
+			}
+
+			sums := &partials[worker]
+			at := func(x int, y int) float64 {
+				return Luma(r.at(x, y))
+			}
+
+			for x := rect.Min.X + 1; x < rect.Max.X-1; x++ {
+				gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+				gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+				magnitude := math.Sqrt(gx*gx+gy*gy) / maxMagnitude
+				sums.magnitudes[int(math.Min(magnitude*edgeBins, edgeBins-1))]++
+				sums.count++
+
+				laplacian := at(x, y-1) + at(x, y+1) + at(x-1, y) + at(x+1, y) - 4*at(x, y)
+				sums.laplacianCount++
+				sums.laplacianSum += laplacian
+				sums.laplacianSq += laplacian * laplacian
+			}
+		})
+
+		for _, partial := range partials {
+			r.edge.count += partial.count
+			r.edge.laplacianCount += partial.laplacianCount
+			r.edge.laplacianSum += partial.laplacianSum
+			r.edge.laplacianSq += partial.laplacianSq
+			for bin := range partial.magnitudes {
+				r.edge.magnitudes[bin] += partial.magnitudes[bin]
+			}
+		}
+	})
+
+	return &r.edge
+}
+
+func (r *RegionStats) at(x int, y int) color.NRGBA {
+	i := r.img.PixOffset(x, y)
+	pix := r.img.Pix[i : i+4 : i+4]
+	return color.NRGBA{R: pix[0], G: pix[1], B: pix[2], A: 255}
+}
+
+// eachPixel calls fun for every pixel in the region, spread across workers
+// fun must only touch state belonging to the given worker index
+func (r *RegionStats) eachPixel(fun func(worker int, c color.NRGBA)) {
+	r.eachRow(func(worker int, rect image.Rectangle, y int) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			fun(worker, r.at(x, y))
+		}
+	})
+}
+
+// eachRow calls fun for every row of every rect in the region, spread across workers
+func (r *RegionStats) eachRow(fun func(worker int, rect image.Rectangle, y int)) {
+	type row struct {
+		rect image.Rectangle
+		y    int
+	}
+
+	var rows []row
+	for _, rect := range r.rects {
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			rows = append(rows, row{rect: rect, y: y})
+		}
+	}
+
+	parallel(len(rows), func(worker int, start int, end int) {
+		for _, current := range rows[start:end] {
+			fun(worker, current.rect, current.y)
+		}
+	})
+}
+
+func workerCount() int {
+	return runtime.NumCPU()
+}
+
+// parallel splits the range 0..n into contiguous chunks, one per worker, and waits for them to finish
+func parallel(n int, work func(worker int, start int, end int)) {
+	workers := workerCount()
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		start := worker * chunk
+		end := start + chunk
+		if end > n {
+			end = n
+		}
+		if start >= end {
+			break
+		}
+
+		wg.Add(1)
+		go func(worker int, start int, end int) {
+			defer wg.Done()
+			work(worker, start, end)
+		}(worker, start, end)
+	}
+	wg.Wait()
+}
+
+// downscale box filters img so that neither dimension exceeds maxSize
+// large source blocks are sampled on a grid rather than read in full, which is
+// indistinguishable for statistics purposes and keeps 8K images cheap
+func downscale(img image.Image, maxSize int) *image.RGBA {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	scale := math.Max(1, math.Max(float64(width), float64(height))/float64(maxSize))
+	dstWidth := int(math.Max(1, math.Round(float64(width)/scale)))
+	dstHeight := int(math.Max(1, math.Round(float64(height)/scale)))
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	if width == 0 || height == 0 {
+		return dst
+	}
+
+	sample := sampler(img)
+	const samplesPerAxis = 4
+	parallel(dstHeight, func(_ int, start int, end int) {
+		for dy := start; dy < end; dy++ {
+			y0 := bounds.Min.Y + dy*height/dstHeight
+			y1 := bounds.Min.Y + (dy+1)*height/dstHeight
+			yStep := maxInt(1, (y1-y0)/samplesPerAxis)
+			for dx := 0; dx < dstWidth; dx++ {
+				x0 := bounds.Min.X + dx*width/dstWidth
+				x1 := bounds.Min.X + (dx+1)*width/dstWidth
+				xStep := maxInt(1, (x1-x0)/samplesPerAxis)
+
+				var red, green, blue, count uint32
+				for y := y0; y < y1; y += yStep {
+					for x := x0; x < x1; x += xStep {
+						r, g, b := sample(x, y)
+						red += uint32(r)
+						green += uint32(g)
+						blue += uint32(b)
+						count++
+					}
+				}
+
+				i := dst.PixOffset(dx, dy)
+				dst.Pix[i] = uint8(red / count)
+				dst.Pix[i+1] = uint8(green / count)
+				dst.Pix[i+2] = uint8(blue / count)
+				dst.Pix[i+3] = 255
+			}
+		}
+	})
+
+	return dst
+}
+
+// sampler returns a fast pixel accessor for the common concrete image types
+func sampler(img image.Image) func(x int, y int) (uint8, uint8, uint8) {
+	switch i := img.(type) {
+	case *image.YCbCr:
+		return func(x int, y int) (uint8, uint8, uint8) {
+			ci := i.COffset(x, y)
+			return color.YCbCrToRGB(i.Y[i.YOffset(x, y)], i.Cb[ci], i.Cr[ci])
+		}
+	case *image.RGBA:
+		return func(x int, y int) (uint8, uint8, uint8) {
+			o := i.PixOffset(x, y)
+			return i.Pix[o], i.Pix[o+1], i.Pix[o+2]
+		}
+	case *image.NRGBA:
+		return func(x int, y int) (uint8, uint8, uint8) {
+			o := i.PixOffset(x, y)
+			return i.Pix[o], i.Pix[o+1], i.Pix[o+2]
+		}
+	case *image.Gray:
+		return func(x int, y int) (uint8, uint8, uint8) {
+			v := i.GrayAt(x, y).Y
+			return v, v, v
+		}
+	}
+
+	return func(x int, y int) (uint8, uint8, uint8) {
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		return c.R, c.G, c.B
+	}
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -1,7 +1,12 @@
 package background
 
 import (
+	"bytes"
+	"errors"
+	_ "golang.org/x/image/webp"
 	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"math/rand"
 	"time"
 )
@@ -13,6 +18,13 @@ type Background interface {
 	GetMetadata(name string) string
 	AddMetadata(name string, value string)
 	GetImage() image.Image
+	GetOriginal() ([]byte, string)
+	GetAnalysis() *Analysis
+	GetDimensions() (image.Point, error)
+	SetDimensions(width int, height int)
+	IsLoaded() bool
+	Load() error
+	Unload() bool
 	IsActive() bool
 	SetActive()
 	SetInactive()
@@ -20,10 +32,39 @@ type Background interface {
 	GenerateExpiry(minDays int, maxDays int)
 }
 
+// Fetcher retrieves the encoded bytes of a background's image
+type Fetcher func() ([]byte, error)
+
 func FromImage(img image.Image, identifier string) Background {
 	return &bg{
 		name:               identifier,
 		image:              img,
+		analysis:           newAnalysis(img),
+		createDate:         time.Now(),
+		additionalMetadata: map[string]string{},
+		isActive:           false,
+	}
+}
+
+// FromFetcher creates a background whose image is only fetched and decoded once it's needed,
+// letting cheap filters reject it before the image is downloaded
+func FromFetcher(fetch Fetcher, identifier string) Background {
+	return &bg{
+		name:               identifier,
+		fetch:              fetch,
+		createDate:         time.Now(),
+		additionalMetadata: map[string]string{},
+		isActive:           false,
+	}
+}
+
+// FromAnalysis recreates a background from the downscaled copy its analysis was made from and the size of the
+// original, letting filters judge it again once the original is gone. it has no full size image
+func FromAnalysis(small image.Image, width int, height int, identifier string) Background {
+	return &bg{
+		name:               identifier,
+		analysis:           newAnalysis(small),
+		dimensions:         &image.Point{X: width, Y: height},
 		createDate:         time.Now(),
 		additionalMetadata: map[string]string{},
 		isActive:           false,
@@ -36,7 +77,13 @@ type bg struct {
 	createDate         time.Time
 	expiresOnDate      time.Time
 	image              image.Image
+	analysis           *Analysis
 	additionalMetadata map[string]string
+
+	fetch      Fetcher
+	encoded    []byte
+	format     string
+	dimensions *image.Point
 }
 
 func (b *bg) IsActive() bool {
@@ -71,6 +118,110 @@ func (b *bg) GetImage() image.Image {
 	return b.image
 }
 
+// GetAnalysis returns the shared downscaled image and statistics cache used by pixel filters
+func (b *bg) GetAnalysis() *Analysis {
+	return b.analysis
+}
+
+// GetDimensions returns the size of the image without decoding it when possible,
+// preferring the decoded image, then dimensions provided by the source, then the encoded image's header
+func (b *bg) GetDimensions() (image.Point, error) {
+	if b.image != nil {
+		return b.image.Bounds().Size(), nil
+	}
+
+	if b.dimensions != nil {
+		return *b.dimensions, nil
+	}
+
+	encoded, err := b.getEncoded()
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(encoded))
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	b.dimensions = &image.Point{X: config.Width, Y: config.Height}
+	return *b.dimensions, nil
+}
+
+// SetDimensions lets sources provide the image's size before it's fetched
+func (b *bg) SetDimensions(width int, height int) {
+	b.dimensions = &image.Point{X: width, Y: height}
+}
+
+func (b *bg) IsLoaded() bool {
+	return b.image != nil || b.analysis != nil
+}
+
+// Load fetches and decodes the image if that hasn't happened yet
+func (b *bg) Load() error {
+	if b.IsLoaded() {
+		return nil
+	}
+
+	encoded, err := b.getEncoded()
+	if err != nil {
+		return err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+
+	b.image = img
+	b.format = format
+	b.analysis = newAnalysis(img)
+	b.addExifMetadata(encoded)
+	return nil
+}
+
+// Unload drops the decoded and encoded image so only the metadata is held, returning whether it can be
+// loaded again. backgrounds that can't be fetched again keep their image
+func (b *bg) Unload() bool {
+	if b.fetch == nil {
+		return false
+	}
+
+	b.image = nil
+	b.analysis = nil
+	b.encoded = nil
+	b.format = ""
+	return true
+}
+
+// GetOriginal returns the encoded image as the source served it along with its format, IE jpeg or png
+// backgrounds created from an already decoded image have no original and return nil
+func (b *bg) GetOriginal() ([]byte, string) {
+	if b.image == nil || len(b.format) == 0 {
+		return nil, ""
+	}
+
+	return b.encoded, b.format
+}
+
+func (b *bg) getEncoded() ([]byte, error) {
+	if b.encoded != nil {
+		return b.encoded, nil
+	}
+
+	if b.fetch == nil {
+		return nil, errors.New("background has no image to fetch")
+	}
+
+	encoded, err := b.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	b.encoded = encoded
+	return encoded, nil
+}
+
 func (b *bg) GetName() string {
 	return b.name
 }
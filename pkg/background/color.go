@@ -0,0 +1,129 @@
+package background
+
+import (
+	"image/color"
+	"math"
+)
+
+// Lab is a color in the CIE L*a*b* color space, where euclidean distance
+// roughly matches perceived difference
+type Lab struct {
+	L float64
+	A float64
+	B float64
+}
+
+// srgbToLinear maps 8 bit sRGB channel values to linear light
+var srgbToLinear [256]float64
+
+func init() {
+	for i := range srgbToLinear {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			srgbToLinear[i] = c / 12.92
+		} else {
+			srgbToLinear[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+}
+
+// LinearRGB converts a color to linear light rgb in the range 0..1
+func LinearRGB(c color.NRGBA) (float64, float64, float64) {
+	return srgbToLinear[c.R], srgbToLinear[c.G], srgbToLinear[c.B]
+}
+
+// XYZ converts a color to CIE XYZ using the D65 white point
+func XYZ(c color.NRGBA) (float64, float64, float64) {
+	r, g, b := LinearRGB(c)
+	x := 0.4124564*r + 0.3575761*g + 0.1804375*b
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := 0.0193339*r + 0.1191920*g + 0.9503041*b
+	return x, y, z
+}
+
+// LabFromColor converts a color to CIE L*a*b* using the D65 white point
+func LabFromColor(c color.NRGBA) Lab {
+	x, y, z := XYZ(c)
+	fx := labF(x / 0.95047)
+	fy := labF(y)
+	fz := labF(z / 1.08883)
+
+	return Lab{
+		L: 116*fy - 16,
+		A: 500 * (fx - fy),
+		B: 200 * (fy - fz),
+	}
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389.0 {
+		return math.Cbrt(t)
+	}
+
+	return (24389.0/27.0*t + 16) / 116
+}
+
+// Distance computes the CIE76 delta E between two colors
+// a distance of about 2.3 is just noticeable, black to white is 100
+func (l Lab) Distance(other Lab) float64 {
+	dL := l.L - other.L
+	dA := l.A - other.A
+	dB := l.B - other.B
+	return math.Sqrt(dL*dL + dA*dA + dB*dB)
+}
+
+// Luma computes the relative luminance of a color in the range 0..1
+func Luma(c color.NRGBA) float64 {
+	return (0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)) / 255
+}
+
+// Saturation computes the HSV saturation of a color, or the HSL saturation when hsl is set
+func Saturation(c color.NRGBA, hsl bool) float64 {
+	max := math.Max(float64(c.R), math.Max(float64(c.G), float64(c.B))) / 255
+	min := math.Min(float64(c.R), math.Min(float64(c.G), float64(c.B))) / 255
+	if max == min {
+		return 0
+	}
+
+	if !hsl {
+		return (max - min) / max
+	}
+
+	lightness := (max + min) / 2
+	return (max - min) / (1 - math.Abs(2*lightness-1))
+}
+
+// chromaticity of the D65 white point
+const (
+	whiteD65X = 0.31271
+	whiteD65Y = 0.32902
+)
+
+// CCT estimates the correlated color temperature in kelvin of an xy chromaticity
+// using McCamy's approximation, clamped to 1000..25000 where it stops being meaningful
+func CCT(x float64, y float64) float64 {
+	n := (x - 0.3320) / (0.1858 - y)
+	cct := 449*n*n*n + 3525*n*n + 6823.3*n + 5520.33
+	return math.Max(1000, math.Min(25000, cct))
+}
+
+// Duv estimates the distance of an xy chromaticity from the planckian locus at the given temperature
+// positive values are tinted green, negative values magenta
+func Duv(x float64, y float64, cct float64) float64 {
+	// CIE 1960 UCS
+	denominator := -2*x + 12*y + 3
+	u := 4 * x / denominator
+	v := 6 * y / denominator
+
+	// Krystek's approximation of the planckian locus
+	t := cct
+	locusU := (0.860117757 + 1.54118254e-4*t + 1.28641212e-7*t*t) / (1 + 8.42420235e-4*t + 7.08145163e-7*t*t)
+	locusV := (0.317398726 + 4.22806245e-5*t + 4.20481691e-8*t*t) / (1 - 2.89741816e-5*t + 1.61456053e-7*t*t)
+
+	duv := math.Hypot(u-locusU, v-locusV)
+	if v < locusV {
+		return -duv
+	}
+
+	return duv
+}
@@ -0,0 +1,70 @@
+package background
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rwcarlsen/goexif/exif"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadata keys populated from an image's EXIF data when it's loaded
+const (
+	ExifDateTaken   = "exif-date-taken"
+	ExifMake        = "exif-make"
+	ExifModel       = "exif-model"
+	ExifFocalLength = "exif-focal-length"
+	ExifLatitude    = "exif-gps-latitude"
+	ExifLongitude   = "exif-gps-longitude"
+	ExifOrientation = "exif-orientation"
+)
+
+// HasExif reports whether any EXIF data was found for the background
+func HasExif(b Background) bool {
+	for _, key := range b.GetMetadataKeys() {
+		if strings.HasPrefix(key, "exif-") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addExifMetadata parses whatever EXIF data the encoded image carries into metadata
+// images without EXIF data, such as most PNGs, are left untouched
+func (b *bg) addExifMetadata(encoded []byte) {
+	x, err := exif.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		return
+	}
+
+	if taken, err := x.DateTime(); err == nil {
+		b.AddMetadata(ExifDateTaken, taken.Format(time.RFC3339))
+	}
+
+	for key, field := range map[string]exif.FieldName{ExifMake: exif.Make, ExifModel: exif.Model} {
+		if tag, err := x.Get(field); err == nil {
+			if val, err := tag.StringVal(); err == nil && len(strings.TrimSpace(val)) != 0 {
+				b.AddMetadata(key, strings.TrimSpace(val))
+			}
+		}
+	}
+
+	if tag, err := x.Get(exif.FocalLength); err == nil {
+		if num, denom, err := tag.Rat2(0); err == nil && denom != 0 {
+			b.AddMetadata(ExifFocalLength, strconv.FormatFloat(float64(num)/float64(denom), 'f', 1, 64))
+		}
+	}
+
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if orientation, err := tag.Int(0); err == nil {
+			b.AddMetadata(ExifOrientation, strconv.Itoa(orientation))
+		}
+	}
+
+	if lat, long, err := x.LatLong(); err == nil {
+		b.AddMetadata(ExifLatitude, fmt.Sprintf("%.6f", lat))
+		b.AddMetadata(ExifLongitude, fmt.Sprintf("%.6f", long))
+	}
+}
@@ -0,0 +1,52 @@
+package background
+
+import (
+	"image/color"
+	"math/bits"
+)
+
+// PerceptualHash computes the 64 bit difference hash of the image
+// similar looking images differ in few bits, regardless of their size or compression
+func (a *Analysis) PerceptualHash() uint64 {
+	img := a.Image()
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// average luma over a 9x8 grid, each row yields 8 bits by comparing neighbouring cells
+	var sums [8][9]float64
+	var counts [8][9]float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := (y - bounds.Min.Y) * 8 / height
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			col := (x - bounds.Min.X) * 9 / width
+			i := img.PixOffset(x, y)
+			sums[row][col] += Luma(color.NRGBA{R: img.Pix[i], G: img.Pix[i+1], B: img.Pix[i+2], A: 255})
+			counts[row][col]++
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			left, right := 0.0, 0.0
+			if counts[row][col] != 0 {
+				left = sums[row][col] / counts[row][col]
+			}
+			if counts[row][col+1] != 0 {
+				right = sums[row][col+1] / counts[row][col+1]
+			}
+
+			hash <<= 1
+			if left < right {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// HashDistance counts the bits two perceptual hashes differ by, from 0 for near identical images up to 64
+func HashDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
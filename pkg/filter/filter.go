@@ -2,6 +2,7 @@
 
 import (
 	"bgfreshd/pkg/background"
+	"fmt"
 	"github.com/sirupsen/logrus"
 )
 
@@ -9,7 +10,107 @@
 type FactoryFunc func(bgFilter *Configuration, log *logrus.Entry) (Filter, error)
 
 type Filter interface {
-	IsValid(img background.Background) bool
+	// IsValid checks the background against the filter, returning nil when it passes
+	// or a Rejection describing why it didn't
+	IsValid(img background.Background) *Rejection
+}
+
+// Rejection describes why a filter refused a background
+type Rejection struct {
+	// Reason is a short human readable explanation, IE "width 1920 < minX 2560"
+	Reason string
+
+	// Transient marks rejections caused by a passing condition, such as a failed download,
+	// which the pipeline shouldn't remember
+	Transient bool
+}
+
+// Reject creates a rejection with a formatted reason
+func Reject(format string, args ...interface{}) *Rejection {
+	return &Rejection{Reason: fmt.Sprintf(format, args...)}
+}
+
+// RejectTransient creates a rejection that may not hold on a later attempt
+func RejectTransient(format string, args ...interface{}) *Rejection {
+	return &Rejection{Reason: fmt.Sprintf(format, args...), Transient: true}
+}
+
+func (r *Rejection) String() string {
+	return r.Reason
+}
+
+// Phase describes what a filter needs from a background, cheaper phases run first
+type Phase int
+
+const (
+	// PhaseMetadata filters only read metadata, which sources provide up front
+	PhaseMetadata Phase = iota
+
+	// PhaseDimensions filters need the image size, which is known before the image is decoded
+	// and often before it's downloaded
+	PhaseDimensions
+
+	// PhasePixels filters need the fully decoded image
+	PhasePixels
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseMetadata:
+		return "metadata"
+	case PhaseDimensions:
+		return "dimensions"
+	}
+
+	return "pixels"
+}
+
+// Phased is implemented by filters that can run before the image is decoded
+// filters that don't implement it are assumed to need pixels
+type Phased interface {
+	Filter
+	Phase() Phase
+}
+
+// PhaseOf returns the phase a filter runs in
+func PhaseOf(f Filter) Phase {
+	if phased, ok := f.(Phased); ok {
+		return phased.Phase()
+	}
+
+	return PhasePixels
+}
+
+// Volatile is implemented by filters that can reach a different verdict for the same background later on,
+// such as random filters, so their rejections are never remembered
+type Volatile interface {
+	Filter
+	IsVolatile() bool
+}
+
+// IsVolatile reports whether a filter's verdicts can change between runs
+func IsVolatile(f Filter) bool {
+	if volatile, ok := f.(Volatile); ok {
+		return volatile.IsVolatile()
+	}
+
+	return false
+}
+
+// Fingerprinter is implemented by filters whose verdicts depend on more than their configuration,
+// such as files read when the filter is created. remembered rejections are dropped when the fingerprint changes
+type Fingerprinter interface {
+	Filter
+	Fingerprint() string
+}
+
+// Scorer is implemented by filters that can rate how well a background fits them
+// scores are used by the pipeline's tournament mode to pick the best of several candidates
+type Scorer interface {
+	Filter
+
+	// Score rates the background from 0, a poor fit, to 1, a perfect fit
+	Score(img background.Background) float64
 }
 
 // Configuration describes settings and type of a filter
@@ -17,6 +118,12 @@ type Configuration struct {
 	// Type of the filter
 	Type string `yaml:"type"`
 
+	// Name of the filter used in logs and statistics, defaults to the type
+	Name string `yaml:"name,omitempty"`
+
 	// Options specific to the filter
 	Options map[string]interface{} `yaml:"options"`
+
+	// Weight of the filter's score when picking between candidates
+	Weight *float64 `yaml:"weight,omitempty"`
 }
@@ -0,0 +1,35 @@
+package sink
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// FactoryFunc describes how to construct a sink
+type FactoryFunc func(cfg *Configuration, log *logrus.Entry) (OutputSink, error)
+
+// OutputSink mirrors the files written for active backgrounds to another location, IE network storage.
+// names are slash separated and relative to the output path
+type OutputSink interface {
+	// Put stores data under name, replacing what was there without exposing a partial file
+	Put(name string, data []byte) error
+
+	// Remove deletes name, succeeding if it's already gone
+	Remove(name string) error
+
+	// List returns the name of every file the sink holds, so it can be brought back in line with the output path
+	List() ([]string, error)
+
+	GetName() string
+}
+
+// Configuration describes settings and type of a sink
+type Configuration struct {
+	// Type of the sink
+	Type string `yaml:"type"`
+
+	// Name of the sink used in logs, defaults to the type
+	Name string `yaml:"name,omitempty"`
+
+	// Options specific to the sink
+	Options interface{} `yaml:"options"`
+}